@@ -0,0 +1,62 @@
+// Package appdir resolves where recall keeps its own files on disk
+// (offset state, generated credentials, local trajectories).
+//
+// Everything lives under a single "recall" directory so a user can inspect
+// or delete it in one place. RECALL_HOME overrides the location entirely.
+package appdir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Dir returns recall's data directory, creating it (mode 0700) if needed.
+//
+// Resolution order:
+//  1. $RECALL_HOME
+//  2. $XDG_DATA_HOME/recall, or ~/.local/share/recall (Linux and other Unixes)
+//  3. os.UserConfigDir()/recall (macOS, Windows)
+func Dir() (string, error) {
+	dir := os.Getenv("RECALL_HOME")
+	if dir == "" {
+		base, err := baseDir()
+		if err != nil {
+			return "", fmt.Errorf("resolve data dir: %w", err)
+		}
+		dir = filepath.Join(base, "recall")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("create data dir: %w", err)
+	}
+	return dir, nil
+}
+
+// Path joins elem onto the data directory, creating parent directories.
+func Path(elem ...string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(append([]string{dir}, elem...)...)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", fmt.Errorf("create %s: %w", filepath.Dir(path), err)
+	}
+	return path, nil
+}
+
+func baseDir() (string, error) {
+	switch runtime.GOOS {
+	case "darwin", "windows", "ios", "plan9":
+		return os.UserConfigDir()
+	}
+	if xdg := os.Getenv("XDG_DATA_HOME"); xdg != "" {
+		return xdg, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
@@ -0,0 +1,59 @@
+// Package buildinfo reports which build of recall is running.
+package buildinfo
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+// Version is the release version, set at build time with
+//
+//	go build -ldflags "-X github.com/shshwtsuthar/recall/internal/buildinfo.Version=v1.2.3"
+//
+// Builds without it report the module version go install recorded, or "dev".
+var Version = ""
+
+var (
+	once   sync.Once
+	info   *debug.BuildInfo
+	haveBI bool
+)
+
+func read() (*debug.BuildInfo, bool) {
+	once.Do(func() { info, haveBI = debug.ReadBuildInfo() })
+	return info, haveBI
+}
+
+// ReleaseVersion returns Version, falling back to the module version and
+// then to "dev".
+func ReleaseVersion() string {
+	if Version != "" {
+		return Version
+	}
+	if bi, ok := read(); ok && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		return bi.Main.Version
+	}
+	return "dev"
+}
+
+// Commit returns the VCS revision the binary was built from, suffixed with
+// "-dirty" for builds of a modified tree, or "" when unknown.
+func Commit() string {
+	bi, ok := read()
+	if !ok {
+		return ""
+	}
+	var revision, modified string
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		}
+	}
+	if revision != "" && modified == "true" {
+		revision += "-dirty"
+	}
+	return revision
+}
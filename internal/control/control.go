@@ -0,0 +1,159 @@
+// Package control is the runtime control channel of a running recall
+// process: a Unix socket in the app dir, one per process, that takes one
+// command per connection.
+//
+// The protocol is line-based. The client writes a command line ("pause",
+// "pause <session-id>", "status", ...), and the server answers with one
+// line: "ok <text>" or "error <text>".
+package control
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shshwtsuthar/recall/internal/appdir"
+)
+
+// ioTimeout bounds one command exchange.
+const ioTimeout = 5 * time.Second
+
+// Handler executes a command and returns the text of the reply.
+type Handler func(verb string, args []string) (string, error)
+
+// Server is a listening control socket.
+type Server struct {
+	ln   net.Listener
+	path string
+}
+
+// Listen creates this process's control socket and serves commands with
+// handle until Close.
+func Listen(handle Handler) (*Server, error) {
+	path, err := appdir.Path("control", strconv.Itoa(os.Getpid())+".sock")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(path) // a stale socket from an earlier process with our pid
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listen on control socket: %w", err)
+	}
+	os.Chmod(path, 0o600)
+
+	s := &Server{ln: ln, path: path}
+	go s.serve(handle)
+	return s, nil
+}
+
+// Path returns the socket's path.
+func (s *Server) Path() string { return s.path }
+
+// Close stops serving and removes the socket.
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	os.Remove(s.path)
+	return err
+}
+
+func (s *Server) serve(handle Handler) {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				fmt.Fprintf(os.Stderr, "[recall/control] %v\n", err)
+			}
+			return
+		}
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(ioTimeout))
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				fmt.Fprintf(conn, "error empty command\n")
+				return
+			}
+			reply, err := handle(fields[0], fields[1:])
+			if err != nil {
+				fmt.Fprintf(conn, "error %s\n", oneLine(err.Error()))
+				return
+			}
+			fmt.Fprintf(conn, "ok %s\n", oneLine(reply))
+		}()
+	}
+}
+
+// Reply is one process's answer to a broadcast command.
+type Reply struct {
+	PID  int
+	Text string
+	Err  error
+}
+
+// Broadcast sends a command to every running recall process and returns
+// their replies, ordered by PID. Sockets left behind by processes that
+// exited are removed.
+func Broadcast(verb string, args ...string) ([]Reply, error) {
+	dir, err := appdir.Path("control")
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.sock"))
+	if err != nil {
+		return nil, err
+	}
+	var replies []Reply
+	for _, path := range paths {
+		pid, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), ".sock"))
+		if err != nil {
+			continue
+		}
+		text, err := send(path, strings.Join(append([]string{verb}, args...), " "))
+		if errors.Is(err, errNotRunning) {
+			os.Remove(path)
+			continue
+		}
+		replies = append(replies, Reply{PID: pid, Text: text, Err: err})
+	}
+	sort.Slice(replies, func(i, j int) bool { return replies[i].PID < replies[j].PID })
+	return replies, nil
+}
+
+var errNotRunning = errors.New("process not running")
+
+// send performs one command exchange over the socket at path.
+func send(path, line string) (string, error) {
+	conn, err := net.DialTimeout("unix", path, ioTimeout)
+	if err != nil {
+		return "", errNotRunning
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ioTimeout))
+	if _, err := fmt.Fprintf(conn, "%s\n", line); err != nil {
+		return "", err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("read reply: %w", err)
+	}
+	status, text, _ := strings.Cut(strings.TrimSpace(reply), " ")
+	if status != "ok" {
+		return "", errors.New(text)
+	}
+	return text, nil
+}
+
+func oneLine(s string) string {
+	return strings.ReplaceAll(s, "\n", " ")
+}
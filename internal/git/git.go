@@ -0,0 +1,30 @@
+// Package git runs git for the stages that look at the user's repository,
+// and reads and normalizes a workspace's remote URL.
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Timeout bounds every git invocation so a wedged repository can't stall us.
+const Timeout = 10 * time.Second
+
+// Run runs a git command in dir and returns its stdout without the trailing
+// newline.
+func Run(dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	// Never take the index lock: the user's own git commands come first.
+	cmd.Env = append(os.Environ(), "GIT_OPTIONAL_LOCKS=0")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
@@ -0,0 +1,270 @@
+// Package metrics is a small, dependency-free registry of counters, gauges
+// and histograms, rendered in the Prometheus text exposition format.
+//
+// Metrics are registered once, at package init, by the component they
+// describe; updating them is cheap and safe from any goroutine. Nothing is
+// exported over the network unless the caller serves Handler.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric is anything the registry can render.
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []metric
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, existing := range registry {
+		if existing.name() == m.name() {
+			panic("metrics: duplicate metric " + m.name())
+		}
+	}
+	registry = append(registry, m)
+}
+
+// WriteText renders every registered metric, sorted by name.
+func WriteText(w io.Writer) {
+	registryMu.Lock()
+	all := append([]metric(nil), registry...)
+	registryMu.Unlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].name() < all[j].name() })
+	for _, m := range all {
+		m.write(w)
+	}
+}
+
+// Handler serves WriteText.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteText(w)
+	})
+}
+
+// Counter is a monotonically increasing value, optionally split by labels.
+type Counter struct {
+	metricName string
+	help       string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64 // joined label values → value
+}
+
+// NewCounter registers a counter with the given label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{metricName: name, help: help, labels: labels, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+// Inc adds 1 for the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v (which must not be negative) for the given label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := joinLabels(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+// Total returns the sum over all label values.
+func (c *Counter) Total() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	total := 0.0
+	for _, v := range c.values {
+		total += v
+	}
+	return total
+}
+
+// Values returns a copy of the per-label-set values, keyed by the first
+// label's value (the common case of a single label).
+func (c *Counter) Values() map[string]float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]float64, len(c.values))
+	for key, v := range c.values {
+		first, _, _ := strings.Cut(key, "\x00")
+		out[first] += v
+	}
+	return out
+}
+
+func (c *Counter) name() string { return c.metricName }
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.metricName, c.help, "counter")
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.metricName)
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, formatLabels(c.labels, key), formatFloat(c.values[key]))
+	}
+}
+
+// Gauge reports a value read at collection time.
+type Gauge struct {
+	metricName string
+	help       string
+	read       func() float64
+}
+
+// NewGauge registers a gauge. read is called on every collection and must
+// be safe to call from any goroutine.
+func NewGauge(name, help string, read func() float64) *Gauge {
+	g := &Gauge{metricName: name, help: help, read: read}
+	register(g)
+	return g
+}
+
+// Value returns the current value.
+func (g *Gauge) Value() float64 { return g.read() }
+
+func (g *Gauge) name() string { return g.metricName }
+
+func (g *Gauge) write(w io.Writer) {
+	writeHeader(w, g.metricName, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.read()))
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	metricName string
+	help       string
+	buckets    []float64
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, non-cumulative; last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a histogram with the given upper bounds (sorted
+// ascending; +Inf is implied).
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{metricName: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+	register(h)
+	return h
+}
+
+// Observe records one value.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// Quantile estimates the q-quantile (0 < q < 1) from the buckets: it
+// returns the upper bound of the bucket the quantile falls in, or NaN when
+// nothing has been observed or there are no buckets. A quantile above the
+// largest bucket is clamped to that bucket's bound, so the result is never
+// infinite.
+func (h *Histogram) Quantile(q float64) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 || len(h.buckets) == 0 {
+		return math.NaN()
+	}
+	largest := h.buckets[len(h.buckets)-1]
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			if i < len(h.buckets) {
+				return h.buckets[i]
+			}
+			return largest
+		}
+	}
+	return largest
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) name() string { return h.metricName }
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.metricName, h.help, "histogram")
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", h.metricName, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.metricName, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.metricName, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.metricName, h.count)
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// joinLabels builds the map key for a set of label values, padding or
+// truncating to the declared label count.
+func joinLabels(names, values []string) string {
+	padded := make([]string, len(names))
+	copy(padded, values)
+	return strings.Join(padded, "\x00")
+}
+
+// formatLabels renders {a="x",b="y"} for a joined key.
+func formatLabels(names []string, key string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	values := strings.Split(key, "\x00")
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + strconv.Quote(values[i])
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
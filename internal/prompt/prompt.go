@@ -0,0 +1,169 @@
+// Package prompt reads the directive lines developers type at the start of
+// an ACP prompt to control capture:
+//
+//	#recall off         pause capture for this session
+//	#recall on          resume it
+//	#recall tag <label> label the session's messages from here on
+//
+// Directives are recognized only at the start of the first text block of
+// an upstream session/prompt request; several may be given on consecutive
+// lines. The prefix ("#recall") is configurable.
+//
+// The "directives" pipeline stage (package directive) honors them; the ACP
+// source optionally strips them before the prompt reaches the agent.
+package prompt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultPrefix starts a directive line unless configured otherwise.
+const DefaultPrefix = "#recall"
+
+// maxLabelLen bounds a tag label.
+const maxLabelLen = 64
+
+// Verbs.
+const (
+	Off = "off"
+	On  = "on"
+	Tag = "tag"
+)
+
+// CheckPrefix reports whether prefix can start a directive line.
+func CheckPrefix(prefix string) error {
+	if prefix == "" || strings.ContainsAny(prefix, " \t\n") {
+		return fmt.Errorf("must be a word without spaces")
+	}
+	return nil
+}
+
+// Directive is one parsed directive line.
+type Directive struct {
+	Verb  string
+	Label string // for Tag
+}
+
+// promptRequest is the part of a session/prompt request directives live in.
+type promptRequest struct {
+	Method string `json:"method"`
+	Params struct {
+		SessionID string            `json:"sessionId"`
+		Prompt    []json.RawMessage `json:"prompt"`
+	} `json:"params"`
+}
+
+type contentBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Find returns the directives at the start of a session/prompt request,
+// and the session ID the request names. raw that isn't such a request, or
+// whose prompt doesn't start with a directive, yields none.
+func Find(prefix, raw string) (sessionID string, directives []Directive) {
+	req, block, ok := promptText(raw)
+	if !ok {
+		return "", nil
+	}
+	directives, _, invalid := leading(prefix, block.Text)
+	if invalid != "" {
+		fmt.Fprintf(os.Stderr, "[recall/directive] ignoring %q: want %s off, %s on or %s tag <label>\n", invalid, prefix, prefix, prefix)
+	}
+	return req.Params.SessionID, directives
+}
+
+// Strip returns raw with the leading directive lines removed from the
+// prompt, and whether there were any. The rest of the message is re-encoded
+// unchanged in meaning; a prompt that held only directives is left with an
+// empty text block.
+func Strip(prefix, raw string) (string, bool) {
+	_, block, ok := promptText(raw)
+	if !ok {
+		return raw, false
+	}
+	directives, rest, _ := leading(prefix, block.Text)
+	if len(directives) == 0 {
+		return raw, false
+	}
+
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber() // keep numbers exactly as sent
+	var msg map[string]any
+	if err := dec.Decode(&msg); err != nil {
+		return raw, false
+	}
+	params, _ := msg["params"].(map[string]any)
+	prompt, _ := params["prompt"].([]any)
+	for _, b := range prompt {
+		if block, _ := b.(map[string]any); block["type"] == "text" {
+			block["text"] = rest
+			break
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(msg); err != nil {
+		return raw, false
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), true
+}
+
+// promptText decodes a session/prompt request and its first text block.
+func promptText(raw string) (promptRequest, contentBlock, bool) {
+	var req promptRequest
+	if !strings.Contains(raw, `"session/prompt"`) || json.Unmarshal([]byte(raw), &req) != nil || req.Method != "session/prompt" {
+		return req, contentBlock{}, false
+	}
+	for _, b := range req.Params.Prompt {
+		var block contentBlock
+		if json.Unmarshal(b, &block) == nil && block.Type == "text" {
+			return req, block, true
+		}
+	}
+	return req, contentBlock{}, false
+}
+
+// leading parses the directive lines at the start of text and returns them
+// with the text that follows. invalid is the line that ended them, if it
+// started with the prefix but isn't a valid directive.
+func leading(prefix, text string) (directives []Directive, rest, invalid string) {
+	rest = text
+	for rest != "" {
+		line, after, _ := strings.Cut(rest, "\n")
+		d, ok, prefixed := parse(prefix, line)
+		if !ok {
+			if prefixed {
+				invalid = strings.TrimSpace(line)
+			}
+			break
+		}
+		directives = append(directives, d)
+		rest = after
+	}
+	return directives, rest, invalid
+}
+
+// parse reads one directive line. prefixed reports whether the line starts
+// with the prefix, valid or not.
+func parse(prefix, line string) (d Directive, ok, prefixed bool) {
+	rest, found := strings.CutPrefix(strings.TrimSpace(line), prefix)
+	if !found || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+		return Directive{}, false, false
+	}
+	verb, arg, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	arg = strings.TrimSpace(arg)
+	switch verb = strings.ToLower(verb); {
+	case (verb == Off || verb == On) && arg == "":
+		return Directive{Verb: verb}, true, true
+	case verb == Tag && arg != "" && len(arg) <= maxLabelLen && !strings.Contains(arg, ","):
+		return Directive{Verb: Tag, Label: arg}, true, true
+	}
+	return Directive{}, false, true
+}
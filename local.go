@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/shshwtsuthar/recall/pipeline"
+	"github.com/shshwtsuthar/recall/pipes/localstore"
+	"github.com/shshwtsuthar/recall/pipes/transmitter"
+)
+
+// listLocalSessions prints the sessions in the local store. It returns the
+// exit code.
+func listLocalSessions(w io.Writer) int {
+	dir, err := pipeline.LocalStoreDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[recall] %v\n", err)
+		return 1
+	}
+	sessions, err := localstore.List(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[recall] %v\n", err)
+		return 1
+	}
+	if len(sessions) == 0 {
+		fmt.Fprintf(w, "No stored sessions in %s\n", dir)
+		return 0
+	}
+	fmt.Fprintf(w, "Stored sessions in %s:\n\n", dir)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SESSION\tMESSAGES\tSIZE\tLAST WRITTEN")
+	for _, sess := range sessions {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", sess.Key, sess.Messages, pipeline.FormatBytes(sess.Bytes),
+			sess.LastWrite.Local().Format("2006-01-02 15:04"))
+	}
+	tw.Flush()
+	return 0
+}
+
+// uploadLocalSessions sends the selected sessions from the local store to
+// RECALL_SERVER. It returns the exit code.
+func uploadLocalSessions(w io.Writer, selectors []string) int {
+	if len(selectors) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: recall-proxy upload <session>... (run `recall-proxy sessions` to list them)")
+		return 2
+	}
+	serverURL := os.Getenv("RECALL_SERVER")
+	if serverURL == "" {
+		fmt.Fprintln(os.Stderr, "[recall] upload needs RECALL_SERVER")
+		return 1
+	}
+	dir, err := pipeline.LocalStoreDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[recall] %v\n", err)
+		return 1
+	}
+
+	// Resolve every selector before sending anything.
+	var sessions []localstore.Session
+	for _, sel := range selectors {
+		found, err := localstore.Find(dir, sel)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[recall] %v\n", err)
+			return 1
+		}
+		if len(found) == 0 {
+			fmt.Fprintf(os.Stderr, "[recall] no stored session %q (run `recall-proxy sessions` to list them)\n", sel)
+			return 1
+		}
+		sessions = append(sessions, found...)
+	}
+
+	tx := transmitter.New(serverURL)
+	if blobURL := os.Getenv("RECALL_BLOB_URL"); blobURL != "" {
+		minBytes, _ := strconv.Atoi(os.Getenv("RECALL_BLOB_MIN_BYTES"))
+		tx.UseBlobStore(blobURL, minBytes)
+	}
+	// Payloads keep their connection ID and seq, so a server that
+	// deduplicates as the Payload contract asks drops what an earlier,
+	// interrupted upload already delivered.
+	for _, sess := range sessions {
+		sent := 0
+		err := localstore.Read(sess, func(payload transmitter.Payload) error {
+			if err := tx.Deliver(payload); err != nil {
+				return err
+			}
+			sent++
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[recall] %s: upload stopped after %d of %d messages: %v\n", sess.Key, sent, sess.Messages, err)
+			return 1
+		}
+		fmt.Fprintf(w, "%s: %d messages uploaded\n", sess.Key, sent)
+	}
+	return 0
+}
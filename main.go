@@ -6,39 +6,147 @@
 //
 // Supports multiple source types:
 //   - acp: ACP-compatible IDEs (Zed, JetBrains, Neovim) with agents (claude, gemini, codex, goose)
-//   - claude-cli: Claude Code CLI log files (future)
-//   - vscode: VS Code extension integration (future)
+//   - claude-cli: Claude Code CLI session transcripts (~/.claude/projects/*/*.jsonl)
+//   - vscode: VS Code extension integration over a localhost WebSocket
+//   - http-llm: reverse proxy in front of an OpenAI/Anthropic-compatible HTTP API
+//   - aider: aider chat/input history files in one or more repositories
+//   - pty: interactive terminal agents run under a pseudo-terminal (Linux)
+//   - exec: any helper program emitting messages as NDJSON on stdout
 //
 // Usage:
 //
 //	recall-proxy --source acp --agent claude -- --experimental-acp
 //	recall-proxy --agent claude -- --experimental-acp  (--source defaults to acp)
+//	recall-proxy --agent claude --capture-llm -- --experimental-acp
+//	recall-proxy --source claude-cli [--log-dir <dir>] [--backfill]
+//	recall-proxy --source vscode [--port <port>]
+//	recall-proxy --source http-llm --upstream https://api.anthropic.com [--listen 127.0.0.1:4141]
+//	recall-proxy --source aider [--repo <dir>]... [--backfill]
+//	recall-proxy --source pty --agent aider -- --model sonnet
+//	recall-proxy --source exec --command ./my-plugin -- --plugin-flag
+//	recall-proxy --source acp --agent gemini --source claude-cli -- --experimental-acp
+//	recall-proxy --dry-run[=<file>] --agent claude -- --experimental-acp
+//	                                    (show what would be sent, send nothing)
+//	recall-proxy sources  (list compiled-in sources and their flags)
+//	recall-proxy scrub-rules            (list the scrub rules in effect)
+//	recall-proxy pause [<session-id>]   (pause capture in running processes)
+//	recall-proxy resume [<session-id>]  (resume it)
+//	recall-proxy status                 (show what is paused)
+//	recall-proxy sessions               (list locally stored sessions)
+//	recall-proxy upload <session>...    (send stored sessions to RECALL_SERVER)
 //
 // The "--" separator marks the start of arguments passed directly to the agent.
+// Sources register themselves with package source (see sources.go); every
+// flag other than --source and --dry-run is validated against the chosen
+// source's schema.
+// --source may be repeated to run several sources in one process: flags
+// apply to the --source they follow, and "--" arguments go to the last one.
+//
+// --dry-run runs the whole pipeline but writes each payload to stderr, or to
+// the given file, instead of sending it, with scrub placeholders highlighted
+// on a terminal, and prints a summary of scrub rule hits at exit.
+//
+// Capture can be paused and resumed at runtime without touching the agent:
+// "recall-proxy pause" reaches every running process through its control
+// socket, and SIGUSR1/SIGUSR2 pause and resume one process (Unix only).
 //
 // Environment variables:
 //
-//	RECALL_SERVER   The ingest endpoint URL (required)
+//	RECALL_SERVER   The ingest endpoint URL
 //	                  e.g. https://recall.yourdomain.com/ingest
+//	                  If unset, sessions are kept in the local store in
+//	                  recall's data directory instead, for later upload.
+//	RECALL_LOCAL_MAX_MB  Size cap of the local store; the least recently
+//	                  written files are deleted beyond it (default 1024).
 //	RECALL_SECRETS  Comma-separated list of env var names whose values
 //	                  should be scrubbed from all messages.
 //	                  e.g. DATABASE_URL,INTERNAL_API_KEY,GITHUB_TOKEN
+//	RECALL_SCRUB_RULES  JSON file of scrub rules to add, change or disable
+//	                  (see package scrubber and `recall-proxy scrub-rules`).
+//	RECALL_STAGES   Comma-separated processing chain, in order
+//	                  (default: scrub,scrub-env,enrich,send; see package pipeline).
+//	RECALL_LLM_HOSTS  Comma-separated model API hosts intercepted by
+//	                  --capture-llm (defaults to well-known providers).
+//	RECALL_SHUTDOWN_TIMEOUT  How long shutdown may spend draining queued
+//	                  messages, and again flushing sends (default 5s).
+//	RECALL_IGNORE_PATHS  Comma-separated workspace path globs never captured,
+//	                  e.g. ~/clients/*. A .recallignore file also works.
+//	RECALL_IGNORE_REMOTES  Comma-separated git remote globs never captured,
+//	                  e.g. github.com/acme-corp/*.
+//	RECALL_IGNORE_FILES  Comma-separated file globs whose reads are dropped,
+//	                  e.g. *.pem,.env.
+//	RECALL_DIRECTIVES  Set to 1 to honor "#recall off", "#recall on" and
+//	                  "#recall tag <label>" lines typed at the start of a prompt.
+//	RECALL_DIRECTIVE_PREFIX  The directive prefix (default #recall).
+//	RECALL_STRIP_DIRECTIVES  Set to 1 to also remove directive lines from
+//	                  prompts before they reach the agent. This is the only
+//	                  case where recall modifies traffic.
+//	RECALL_FILTER_FILE  JSON file of include/exclude rules (see package filter).
+//	RECALL_SAMPLE_PERCENT  Capture only this share of sessions (0-100),
+//	                  chosen deterministically by session id.
+//	RECALL_METRICS_ADDR  Serve Prometheus metrics at /metrics on this
+//	                  localhost address, e.g. 127.0.0.1:9464.
+//	RECALL_METRICS_SUMMARY  Print a status line to stderr at this interval,
+//	                  e.g. 5m.
+//	RECALL_GIT_LINK  Set to 1 to link sessions to the git commits made in
+//	                  their workspace.
+//	RECALL_GIT_HOOK  Set to 1 to also install a prepare-commit-msg hook that
+//	                  adds a Recall-Session trailer (implies RECALL_GIT_LINK).
+//	RECALL_RETENTION  Set to 1 to report how many of the agent's proposed
+//	                  edits were kept, modified or reverted.
+//	RECALL_RETENTION_DELAY  How long after a session goes quiet to evaluate
+//	                  it, unless the user commits first (default 1h).
+//	RECALL_BLOB_URL  The server's blob endpoint. When set, large values are
+//	                  uploaded there once and referenced by hash.
+//	RECALL_BLOB_MIN_BYTES  Smallest value moved to a blob (default 4096).
+//	RECALL_ENRICH   Set to 0 to stop attaching the hashed machine, repository
+//	                  and agent labels to every message (see package enrich).
+//	RECALL_HASH_SALT  Secret mixed into those hashes. Use the same value on
+//	                  every machine of a deployment.
+//	RECALL_AGENT_VERSION  Set to 1 to run `<agent> --version` once at start
+//	                  and label messages with the agent's version.
 package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/shshwtsuthar/recall/internal/control"
+	"github.com/shshwtsuthar/recall/internal/prompt"
 	"github.com/shshwtsuthar/recall/pipeline"
+	"github.com/shshwtsuthar/recall/pipes/filter"
+	"github.com/shshwtsuthar/recall/pipes/optout"
+	"github.com/shshwtsuthar/recall/pipes/pause"
+	"github.com/shshwtsuthar/recall/pipes/scrubber"
 	"github.com/shshwtsuthar/recall/source"
-	"github.com/shshwtsuthar/recall/source/acp"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "sources":
+			listSources(os.Stdout)
+			return
+		case "pause", "resume", "status":
+			os.Exit(runControl(os.Stdout, os.Args[1], os.Args[2:]))
+		case "scrub-rules":
+			os.Exit(listScrubRules(os.Stdout))
+		case "sessions":
+			os.Exit(listLocalSessions(os.Stdout))
+		case "upload":
+			os.Exit(uploadLocalSessions(os.Stdout, os.Args[2:]))
+		}
+	}
+
 	cfg, err := parseConfig()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "[recall] config error: %v\n", err)
@@ -50,25 +158,30 @@ func main() {
 	// replace them if they appear verbatim in any message.
 	envSecrets := resolveEnvSecrets(cfg.secretVarNames)
 
-	// Create source based on source type.
-	var src source.Source
-	switch cfg.sourceType {
-	case "acp":
-		src = acp.New(acp.Config{
-			AgentArgs: cfg.agentArgs,
-		})
-	// FUTURE: Additional source types
-	// case "claude-cli":
-	//     src = claudecli.New(claudecli.Config{LogDir: cfg.logDir})
-	// case "vscode":
-	//     src = vscode.New(vscode.Config{WebSocketPort: cfg.wsPort})
-	default:
-		fmt.Fprintf(os.Stderr, "[recall] unknown source type: %s\n", cfg.sourceType)
-		os.Exit(1)
+	// Create the sources through the registry.
+	var (
+		sources []source.Source
+		names   []string
+	)
+	for _, spec := range cfg.sources {
+		src, err := spec.desc.New(spec.opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[recall] config error: %s: %v\n", spec.desc.Name, err)
+			os.Exit(1)
+		}
+		sources = append(sources, src)
+		names = append(names, src.Name())
 	}
 
-	fmt.Fprintf(os.Stderr, "[recall] proxy starting — source: %s | server: %s\n",
-		src.Name(), cfg.serverURL)
+	destination := "server: " + cfg.serverURL
+	switch {
+	case cfg.dryRun:
+		destination = "dry run, nothing is sent"
+	case cfg.serverURL == "":
+		destination = "no server, storing sessions locally (see `recall-proxy sessions`)"
+	}
+	fmt.Fprintf(os.Stderr, "[recall] proxy starting — source: %s | %s\n",
+		strings.Join(names, ", "), destination)
 
 	// Setup context with signal handling for graceful shutdown.
 	// When user presses Ctrl+C (SIGINT) or sends SIGTERM, we cancel the
@@ -81,17 +194,54 @@ func main() {
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-sigChan
-		fmt.Fprintf(os.Stderr, "[recall] shutting down gracefully...\n")
+		fmt.Fprintf(os.Stderr, "[recall] shutting down gracefully... (signal again to force)\n")
 		cancel()
+		<-sigChan
+		fmt.Fprintf(os.Stderr, "[recall] forced exit; unsent messages are lost\n")
+		os.Exit(1)
 	}()
 
+	// Runtime pause/resume, from `recall-proxy pause` and from signals.
+	pauseSwitch := pause.NewSwitch()
+	if server, err := control.Listen(controlHandler(pauseSwitch)); err != nil {
+		fmt.Fprintf(os.Stderr, "[recall] control socket unavailable, pause with signals only: %v\n", err)
+	} else {
+		defer server.Close()
+	}
+	watchPauseSignals(pauseSwitch)
+
 	// Run the pipeline with the selected source.
 	pipelineConfig := pipeline.Config{
-		ServerURL:  cfg.serverURL,
-		EnvSecrets: envSecrets,
+		ServerURL:     cfg.serverURL,
+		LocalMaxBytes: cfg.localMaxBytes,
+		DryRun:        cfg.dryRun,
+		DryRunOutput:  cfg.dryRunOutput,
+		EnvSecrets:    envSecrets,
+		Stages:        cfg.stages,
+		Filter:        cfg.filter,
+		Scrub:         cfg.scrub,
+		OptOut:        cfg.optOut,
+		Pause:         pauseSwitch,
+		Directives:    cfg.directives,
+		GitLink:       cfg.gitLink || cfg.gitHook,
+		GitHook:       cfg.gitHook,
+
+		ShutdownTimeout: cfg.shutdownTimeout,
+		MetricsAddr:     cfg.metricsAddr,
+		MetricsSummary:  cfg.metricsSummary,
+
+		Retention:      cfg.retention,
+		RetentionDelay: cfg.retentionDelay,
+
+		BlobURL:      cfg.blobURL,
+		BlobMinBytes: cfg.blobMinBytes,
+		NoEnrich:     cfg.noEnrich,
+
+		ProbeAgentVersion: cfg.agentVersion,
+		HashSalt:          cfg.hashSalt,
 	}
 
-	if err := pipeline.Run(ctx, src, pipelineConfig); err != nil && err != context.Canceled {
+	if err := pipeline.Run(ctx, pipelineConfig, sources...); err != nil && err != context.Canceled {
 		fmt.Fprintf(os.Stderr, "[recall] proxy exited with error: %v\n", err)
 		os.Exit(1)
 	}
@@ -99,10 +249,35 @@ func main() {
 
 // config holds everything the proxy needs to start.
 type config struct {
-	sourceType     string   // "acp", "claude-cli", "vscode"
-	agentArgs      []string // for ACP source: the agent binary + its arguments
-	serverURL      string   // hive mind ingest endpoint
-	secretVarNames []string // names of env vars whose values should be scrubbed
+	sources         []sourceSpec     // one entry per --source, in command-line order
+	serverURL       string           // hive mind ingest endpoint; "" stores locally
+	localMaxBytes   int64            // local store size cap; 0 for the default
+	dryRun          bool             // write payloads out instead of sending them
+	dryRunOutput    string           // dry-run output file; "" for stderr
+	secretVarNames  []string         // names of env vars whose values should be scrubbed
+	scrub           *scrubber.Config // scrub rules file; nil for the built-ins only
+	stages          []string         // pipeline stage chain; empty for the default
+	filter          *filter.Config   // message filter rules; nil disables
+	optOut          optout.Config    // workspaces, remotes and files never captured
+	directives      string           // in-band directive prefix; "" disables
+	gitLink         bool             // link sessions to git commits
+	gitHook         bool             // install the Recall-Session trailer hook
+	shutdownTimeout time.Duration    // per-phase shutdown deadline; 0 for the default
+	metricsAddr     string           // localhost address for /metrics; "" disables
+	metricsSummary  time.Duration    // stderr status interval; 0 disables
+	retention       bool             // report edit retention outcomes
+	retentionDelay  time.Duration    // quiet time before evaluation; 0 for the default
+	blobURL         string           // blob endpoint; "" disables deduplication
+	blobMinBytes    int              // smallest value moved to a blob; 0 for the default
+	noEnrich        bool             // leave out the environment labels
+	agentVersion    bool             // run <agent> --version for the labels
+	hashSalt        string           // secret mixed into the label hashes
+}
+
+// sourceSpec is one source to run and its parsed flags.
+type sourceSpec struct {
+	desc source.Descriptor
+	opts source.Options
 }
 
 // parseConfig reads configuration from CLI flags and environment variables.
@@ -112,12 +287,27 @@ type config struct {
 // and without requiring a config file to be in a specific location.
 func parseConfig() (config, error) {
 	var cfg config
-	cfg.sourceType = "acp" // Default for backward compatibility
 
 	// Parse flags manually to avoid pulling in flag package complexity.
 	// The structure is:
-	//   recall-proxy [--source <type>] [--agent <binary>] [-- <agent-args...>]
+	//   recall-proxy [--dry-run[=<file>]] [--source <type> [--<source-flag> [value]]...]... [-- <agent-args...>]
+	// --source and --dry-run are the core flags; every other flag belongs to
+	// the --source before it (or to the first one, if it comes before any).
 	args := os.Args[1:]
+	type group struct {
+		sourceType string
+		args       []string
+	}
+	var (
+		groups      []group
+		passThrough []string
+	)
+	current := func() *group {
+		if len(groups) == 0 {
+			groups = append(groups, group{})
+		}
+		return &groups[len(groups)-1]
+	}
 
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
@@ -126,47 +316,360 @@ func parseConfig() (config, error) {
 				return cfg, fmt.Errorf("--source requires a value")
 			}
 			i++
-			cfg.sourceType = args[i]
-
-		case "--agent":
-			if i+1 >= len(args) {
-				return cfg, fmt.Errorf("--agent requires a value")
+			if g := current(); g.sourceType == "" {
+				// Flags given before the first --source belong to it.
+				g.sourceType = args[i]
+			} else {
+				groups = append(groups, group{sourceType: args[i]})
 			}
-			i++
-			// Build agentArgs starting with the binary name.
-			cfg.agentArgs = []string{args[i]}
+
+		case "--dry-run":
+			cfg.dryRun = true
 
 		case "--":
-			// Everything after -- is passed to the agent.
-			cfg.agentArgs = append(cfg.agentArgs, args[i+1:]...)
+			// Everything after -- is passed to the last source's agent.
+			passThrough = args[i+1:]
 			i = len(args) // stop the loop
+
+		default:
+			if path, ok := strings.CutPrefix(args[i], "--dry-run="); ok {
+				cfg.dryRun, cfg.dryRunOutput = true, path
+				continue
+			}
+			g := current()
+			g.args = append(g.args, args[i])
 		}
 	}
+	if g := current(); g.sourceType == "" {
+		g.sourceType = "acp" // Default for backward compatibility
+	}
 
-	// Validate source-specific requirements.
-	if cfg.sourceType == "acp" && len(cfg.agentArgs) == 0 {
-		return cfg, fmt.Errorf("acp source requires --agent. Usage: recall-proxy --source acp --agent <binary> [-- <args>]")
+	for i, g := range groups {
+		desc, ok := source.Lookup(g.sourceType)
+		if !ok {
+			return cfg, fmt.Errorf("unknown source type: %s (run `recall-proxy sources` to list them)", g.sourceType)
+		}
+		var pass []string
+		if i == len(groups)-1 {
+			pass = passThrough
+		}
+		opts, err := parseSourceFlags(desc, g.args, pass)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.sources = append(cfg.sources, sourceSpec{desc: desc, opts: opts})
+	}
+	var stdio []string
+	for _, spec := range cfg.sources {
+		if spec.desc.UsesStdio {
+			stdio = append(stdio, spec.desc.Name)
+		}
+	}
+	if len(stdio) > 1 {
+		return cfg, fmt.Errorf("sources %s all use recall's stdin and stdout; run at most one of them", strings.Join(stdio, ", "))
 	}
 
-	// Server URL from environment.
+	// Server URL from environment. Without one, sessions are kept in the
+	// local store.
 	cfg.serverURL = os.Getenv("RECALL_SERVER")
-	if cfg.serverURL == "" {
-		return cfg, fmt.Errorf("RECALL_SERVER environment variable is required")
+	if raw := os.Getenv("RECALL_LOCAL_MAX_MB"); raw != "" {
+		mb, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || mb <= 0 {
+			return cfg, fmt.Errorf("invalid RECALL_LOCAL_MAX_MB %q: want a positive number of megabytes", raw)
+		}
+		cfg.localMaxBytes = mb << 20
 	}
 
 	// Secret var names from environment.
-	if raw := os.Getenv("RECALL_SECRETS"); raw != "" {
-		for _, name := range strings.Split(raw, ",") {
-			name = strings.TrimSpace(name)
-			if name != "" {
-				cfg.secretVarNames = append(cfg.secretVarNames, name)
+	cfg.secretVarNames = splitList(os.Getenv("RECALL_SECRETS"))
+
+	// Scrub rules, validated now so mistakes are config errors.
+	if path := os.Getenv("RECALL_SCRUB_RULES"); path != "" {
+		rules, err := loadScrubRules(path)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.scrub = &rules
+	}
+
+	// Stage names are checked when the pipeline builds its chain.
+	cfg.stages = splitList(os.Getenv("RECALL_STAGES"))
+
+	// Opt-out denylists, validated now so mistakes are config errors.
+	cfg.optOut = optout.Config{
+		Paths:   splitList(os.Getenv("RECALL_IGNORE_PATHS")),
+		Remotes: splitList(os.Getenv("RECALL_IGNORE_REMOTES")),
+		Files:   splitList(os.Getenv("RECALL_IGNORE_FILES")),
+	}
+	if _, err := optout.New(cfg.optOut); err != nil {
+		return cfg, fmt.Errorf("opt-out: %w", err)
+	}
+
+	// In-band directives, and whether sources strip them from prompts.
+	if os.Getenv("RECALL_DIRECTIVES") == "1" {
+		cfg.directives = prompt.DefaultPrefix
+		if prefix := os.Getenv("RECALL_DIRECTIVE_PREFIX"); prefix != "" {
+			if err := prompt.CheckPrefix(prefix); err != nil {
+				return cfg, fmt.Errorf("invalid RECALL_DIRECTIVE_PREFIX %q: %w", prefix, err)
+			}
+			cfg.directives = prefix
+		}
+		// Sources that can strip directives declare a --strip-directives
+		// flag taking the prefix; an explicit flag wins.
+		if os.Getenv("RECALL_STRIP_DIRECTIVES") == "1" {
+			for _, spec := range cfg.sources {
+				if _, ok := spec.desc.Flag("strip-directives"); ok && spec.opts.String("strip-directives") == "" {
+					spec.opts.Set("strip-directives", cfg.directives)
+				}
 			}
 		}
 	}
 
+	// Filter rules from a file, sampling from the file or the env var (which
+	// wins, so a shared rules file can be sampled differently per team).
+	if path := os.Getenv("RECALL_FILTER_FILE"); path != "" {
+		rules, err := filter.Load(path)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.filter = &rules
+	}
+	if raw := os.Getenv("RECALL_SAMPLE_PERCENT"); raw != "" {
+		percent, err := strconv.ParseFloat(raw, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return cfg, fmt.Errorf("invalid RECALL_SAMPLE_PERCENT %q: want a number from 0 to 100", raw)
+		}
+		if cfg.filter == nil {
+			cfg.filter = &filter.Config{}
+		}
+		cfg.filter.SamplePercent = &percent
+	}
+	if cfg.filter != nil {
+		// Validate now so mistakes are config errors, not pipeline errors.
+		if _, err := filter.New(*cfg.filter); err != nil {
+			return cfg, fmt.Errorf("filter: %w", err)
+		}
+	}
+
+	cfg.gitLink = os.Getenv("RECALL_GIT_LINK") == "1"
+	cfg.gitHook = os.Getenv("RECALL_GIT_HOOK") == "1"
+
+	if raw := os.Getenv("RECALL_SHUTDOWN_TIMEOUT"); raw != "" {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil || timeout <= 0 {
+			return cfg, fmt.Errorf("invalid RECALL_SHUTDOWN_TIMEOUT %q: want a duration like 10s", raw)
+		}
+		cfg.shutdownTimeout = timeout
+	}
+
+	cfg.metricsAddr = os.Getenv("RECALL_METRICS_ADDR")
+	if raw := os.Getenv("RECALL_METRICS_SUMMARY"); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil || interval <= 0 {
+			return cfg, fmt.Errorf("invalid RECALL_METRICS_SUMMARY %q: want a duration like 5m", raw)
+		}
+		cfg.metricsSummary = interval
+	}
+
+	cfg.retention = os.Getenv("RECALL_RETENTION") == "1"
+	if raw := os.Getenv("RECALL_RETENTION_DELAY"); raw != "" {
+		delay, err := time.ParseDuration(raw)
+		if err != nil || delay <= 0 {
+			return cfg, fmt.Errorf("invalid RECALL_RETENTION_DELAY %q: want a duration like 30m", raw)
+		}
+		cfg.retentionDelay = delay
+	}
+
+	cfg.blobURL = os.Getenv("RECALL_BLOB_URL")
+	if raw := os.Getenv("RECALL_BLOB_MIN_BYTES"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid RECALL_BLOB_MIN_BYTES %q: want a positive number of bytes", raw)
+		}
+		cfg.blobMinBytes = n
+	}
+
+	cfg.noEnrich = os.Getenv("RECALL_ENRICH") == "0"
+	cfg.agentVersion = os.Getenv("RECALL_AGENT_VERSION") == "1"
+	cfg.hashSalt = os.Getenv("RECALL_HASH_SALT")
+
 	return cfg, nil
 }
 
+// parseSourceFlags validates args against the source's flag schema.
+// Flags are written --name value, --name=value, or just --name for bool flags.
+func parseSourceFlags(desc source.Descriptor, args, passThrough []string) (source.Options, error) {
+	opts := source.NewOptions(desc)
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") {
+			return opts, fmt.Errorf("unexpected argument %q (agent arguments go after --)", arg)
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+
+		flag, ok := desc.Flag(name)
+		if !ok {
+			return opts, fmt.Errorf("unknown flag --%s for source %s (run `recall-proxy sources` to list flags)", name, desc.Name)
+		}
+		switch {
+		case flag.Bool && !hasValue:
+			value = "true"
+		case !hasValue:
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("--%s requires a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		opts.Set(name, value)
+	}
+
+	if len(passThrough) > 0 && !desc.PassThrough {
+		return opts, fmt.Errorf("source %s does not take arguments after --", desc.Name)
+	}
+	opts.Args = passThrough
+
+	if err := opts.Validate(desc.Name); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// controlHandler serves the control socket's commands.
+func controlHandler(sw *pause.Switch) control.Handler {
+	return func(verb string, args []string) (string, error) {
+		if len(args) > 1 {
+			return "", fmt.Errorf("%s takes at most one session id", verb)
+		}
+		session := strings.Join(args, "")
+		switch verb {
+		case "pause":
+			sw.Pause(session)
+		case "resume":
+			sw.Resume(session)
+		case "status":
+		default:
+			return "", fmt.Errorf("unknown command %q", verb)
+		}
+		status, err := json.Marshal(sw.Status())
+		return string(status), err
+	}
+}
+
+// runControl sends a pause, resume or status command to every running
+// recall process and prints their replies. It returns the exit code.
+func runControl(w io.Writer, verb string, args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintf(os.Stderr, "usage: recall-proxy %s [<session-id>]\n", verb)
+		return 2
+	}
+	replies, err := control.Broadcast(verb, args...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[recall] %v\n", err)
+		return 1
+	}
+	if len(replies) == 0 {
+		fmt.Fprintln(os.Stderr, "[recall] no running recall process found")
+		return 1
+	}
+	code := 0
+	for _, r := range replies {
+		if r.Err != nil {
+			fmt.Fprintf(w, "pid %d: error: %v\n", r.PID, r.Err)
+			code = 1
+			continue
+		}
+		fmt.Fprintf(w, "pid %d: %s\n", r.PID, r.Text)
+	}
+	return code
+}
+
+// listSources prints every registered source with its flags.
+func listSources(w io.Writer) {
+	fmt.Fprintln(w, "Available sources:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, d := range source.Registered() {
+		fmt.Fprintf(tw, "\n  %s\t%s\n", d.Name, d.Description)
+		for _, f := range d.Flags {
+			usage := f.Usage
+			if f.Required {
+				usage += " (required)"
+			}
+			if f.Default != "" {
+				usage += fmt.Sprintf(" (default %s)", f.Default)
+			}
+			name := "--" + f.Name
+			if !f.Bool {
+				name += " <value>"
+			}
+			fmt.Fprintf(tw, "    %s\t%s\n", name, usage)
+		}
+		if d.PassThrough {
+			fmt.Fprintf(tw, "    -- <args...>\targuments passed to the launched program\n")
+		}
+	}
+	tw.Flush()
+}
+
+// loadScrubRules reads and validates a scrub rules file.
+func loadScrubRules(path string) (scrubber.Config, error) {
+	rules, err := scrubber.Load(path)
+	if err != nil {
+		return rules, err
+	}
+	if _, err := scrubber.New(rules); err != nil {
+		return rules, fmt.Errorf("scrub rules file %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// listScrubRules prints the scrub rules in effect: the built-ins, as
+// changed by RECALL_SCRUB_RULES. It returns the exit code.
+func listScrubRules(w io.Writer) int {
+	var rules scrubber.Config
+	if path := os.Getenv("RECALL_SCRUB_RULES"); path != "" {
+		var err error
+		if rules, err = loadScrubRules(path); err != nil {
+			fmt.Fprintf(os.Stderr, "[recall] config error: %v\n", err)
+			return 1
+		}
+	}
+	s, err := scrubber.New(rules)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[recall] config error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(w, "Scrub rules, in the order they run:")
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSEVERITY\tPLACEHOLDER\tORIGIN")
+	for _, r := range s.Rules() {
+		origin := "file"
+		if r.Builtin {
+			origin = "built-in"
+		}
+		if !r.Enabled {
+			origin += ", disabled"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Name, r.Severity, r.Placeholder, origin)
+	}
+	tw.Flush()
+	return 0
+}
+
+// splitList splits a comma-separated env var value, dropping empty entries.
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // resolveEnvSecrets takes a list of environment variable names and returns
 // a map of name → current value. Only variables with non-empty values are included.
 // The map is passed to the scrubber to replace any literal occurrences of these
@@ -0,0 +1,168 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/shshwtsuthar/recall/pipes/transmitter"
+	"github.com/shshwtsuthar/recall/source"
+)
+
+// ANSI escapes framing a highlighted placeholder.
+const (
+	highlightOn  = "\x1b[1;33m"
+	highlightOff = "\x1b[0m"
+)
+
+// dryRunChain returns names with every sink that would leave the machine
+// ("send", and "store" for its later upload) replaced by "dry-run", which
+// is appended if there was none.
+func dryRunChain(names []string) []string {
+	out := make([]string, 0, len(names)+1)
+	found := false
+	for _, name := range names {
+		if name == "send" || name == "store" || name == "dry-run" {
+			if found {
+				continue
+			}
+			name, found = "dry-run", true
+		}
+		out = append(out, name)
+	}
+	if !found {
+		out = append(out, "dry-run")
+	}
+	return out
+}
+
+// dryRunStage writes every message, stamped as the send stage would, to
+// Config.DryRunOutput (stderr if empty) instead of sending it, and prints a
+// summary of what the chain did on Close.
+type dryRunStage struct {
+	out       io.Writer
+	file      *os.File // nil when writing to stderr
+	highlight *regexp.Regexp
+	stamper   *transmitter.Stamper
+	severity  map[string]string // scrub rule name → severity
+
+	payloads int
+	bytes    int
+}
+
+func newDryRunStage(env StageEnv) (Stage, error) {
+	scrub, err := newScrubber(env.Config)
+	if err != nil {
+		return nil, err
+	}
+	s := &dryRunStage{out: os.Stderr, stamper: transmitter.NewStamper(), severity: make(map[string]string)}
+	for _, r := range scrub.Rules() {
+		s.severity[r.Name] = r.Severity
+	}
+	if path := env.Config.DryRunOutput; path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("open dry-run output: %w", err)
+		}
+		s.out, s.file = f, f
+	}
+	if isTerminal(s.out) && os.Getenv("NO_COLOR") == "" {
+		s.highlight = placeholderPattern(scrub.Placeholders())
+	}
+	fmt.Fprintf(os.Stderr, "[recall/dry-run] nothing is sent; payloads are written to %s\n", s.outputName())
+	return s, nil
+}
+
+func (s *dryRunStage) Process(msg source.Message) []source.Message {
+	// Unlike the transmitter, leave <, > and & unescaped, so placeholders
+	// read as they are; the JSON is equivalent.
+	var buf strings.Builder
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s.stamper.Stamp(toPayload(msg))); err != nil {
+		fmt.Fprintf(os.Stderr, "[recall/dry-run] marshal payload: %v\n", err)
+		return []source.Message{msg}
+	}
+	s.payloads++
+	s.bytes += buf.Len()
+	text := buf.String()
+	if s.highlight != nil {
+		text = s.highlight.ReplaceAllString(text, highlightOn+"$0"+highlightOff)
+	}
+	io.WriteString(s.out, text)
+	return []source.Message{msg}
+}
+
+func (s *dryRunStage) Close(context.Context) {
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "[recall/dry-run] %v\n", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "[recall/dry-run] %d payload(s), %s, would have been sent (written to %s)\n",
+		s.payloads, FormatBytes(int64(s.bytes)), s.outputName())
+	hits := make(map[string]float64)
+	for rule, n := range scrubHits.Values() {
+		if sev := s.severity[rule]; sev != "" {
+			rule += " (" + sev + ")"
+		}
+		hits[rule] = n
+	}
+	fmt.Fprintf(os.Stderr, "[recall/dry-run] scrub hits: %s\n", formatCounts(hits))
+	fmt.Fprintf(os.Stderr, "[recall/dry-run] dropped by stage: %s\n", formatCounts(droppedTotal.Values()))
+}
+
+func (s *dryRunStage) outputName() string {
+	if s.file != nil {
+		return s.file.Name()
+	}
+	return "stderr"
+}
+
+// placeholderPattern matches the placeholders the scrubbers put in place of
+// what they removed, as they appear in a JSON-encoded payload.
+func placeholderPattern(placeholders []string) *regexp.Regexp {
+	alternatives := []string{`<ENV:[A-Za-z0-9_]+>`}
+	for _, p := range placeholders {
+		alternatives = append(alternatives, regexp.QuoteMeta(p))
+	}
+	return regexp.MustCompile(strings.Join(alternatives, "|"))
+}
+
+// formatCounts renders counts by label, largest first, e.g.
+// "email 3, private-ip 1".
+func formatCounts(counts map[string]float64) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s %.0f", k, counts[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,130 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/shshwtsuthar/recall/internal/metrics"
+	"github.com/shshwtsuthar/recall/pipes/transmitter"
+)
+
+var (
+	messagesTotal = metrics.NewCounter("recall_messages_total",
+		"Messages received from sources.", "source", "direction")
+	messageBytes = metrics.NewCounter("recall_message_bytes_total",
+		"Bytes of raw message content received from sources.", "source", "direction")
+	droppedTotal = metrics.NewCounter("recall_messages_dropped_total",
+		"Messages dropped before reaching the end of the stage chain, by reason (the stage that dropped them, or \"shutdown\").",
+		"reason")
+	scrubHits = metrics.NewCounter("recall_scrub_hits_total",
+		"Values replaced by the scrubber, by rule.", "rule")
+
+	// queueLen reports the depth of the running pipeline's message channel.
+	queueLen   atomic.Pointer[func() int]
+	queueDepth = metrics.NewGauge("recall_queue_depth",
+		"Messages waiting in the pipeline channel.",
+		func() float64 {
+			if f := queueLen.Load(); f != nil {
+				return float64((*f)())
+			}
+			return 0
+		})
+)
+
+// startMetrics serves the metrics endpoint and prints the periodic stderr
+// summary, as configured. The returned function stops both. Only an invalid
+// metrics address is an error; if it can't be listened on, recall warns and
+// runs without the endpoint.
+func startMetrics(config Config) (stop func(), err error) {
+	var stops []func()
+	stop = func() {
+		for _, f := range stops {
+			f()
+		}
+	}
+
+	if config.MetricsAddr != "" {
+		host, _, err := net.SplitHostPort(config.MetricsAddr)
+		if err != nil {
+			return nil, fmt.Errorf("metrics address %q: %w", config.MetricsAddr, err)
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return nil, fmt.Errorf("metrics address %q must be on localhost", config.MetricsAddr)
+		}
+		ln, err := net.Listen("tcp", config.MetricsAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[recall] metrics endpoint disabled: %v\n", err)
+		} else {
+			stops = append(stops, serveMetrics(ln))
+		}
+	}
+
+	if config.MetricsSummary > 0 {
+		done := make(chan struct{})
+		go func() {
+			ticker := time.NewTicker(config.MetricsSummary)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					fmt.Fprintf(os.Stderr, "[recall] %s\n", summary())
+				}
+			}
+		}()
+		stops = append(stops, func() { close(done) })
+	}
+
+	return stop, nil
+}
+
+// serveMetrics serves the metrics endpoint on ln. The returned function
+// stops it.
+func serveMetrics(ln net.Listener) (stop func()) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(os.Stderr, "[recall] metrics server: %v\n", err)
+		}
+	}()
+	fmt.Fprintf(os.Stderr, "[recall] metrics on http://%s/metrics\n", ln.Addr())
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}
+}
+
+// summary is a one-line health report built from the metrics.
+func summary() string {
+	tx := transmitter.CurrentHealth()
+	line := fmt.Sprintf("status: %.0f messages (%s), %.0f dropped, %.0f scrubbed values, queue %.0f | sent %d, failed %d, retries %d, in flight %d",
+		messagesTotal.Total(), FormatBytes(int64(messageBytes.Total())), droppedTotal.Total(), scrubHits.Total(), queueDepth.Value(),
+		tx.Sent, tx.Failed, tx.Retries, tx.InFlight)
+	if tx.P50 > 0 {
+		line += fmt.Sprintf(", latency p50 ≤%s p95 ≤%s", tx.P50, tx.P95)
+	}
+	return line
+}
+
+// FormatBytes renders a size for humans, in powers of 1024.
+func FormatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -1,15 +1,23 @@
 // Package pipeline implements source-agnostic message processing.
 //
-// The pipeline consumes messages from any Source, applies PII scrubbing,
-// and transmits sanitized data to the hive mind server. It knows nothing
-// about protocols — it just processes the universal Message format.
+// The pipeline consumes messages from any number of Sources and runs each one
+// through a chain of Stages — by default PII scrubbing followed by
+// transmission to the hive mind server. It knows nothing about protocols —
+// it just processes the universal Message format.
 package pipeline
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
 
+	"github.com/shshwtsuthar/recall/pipes/filter"
+	"github.com/shshwtsuthar/recall/pipes/optout"
+	"github.com/shshwtsuthar/recall/pipes/pause"
 	"github.com/shshwtsuthar/recall/pipes/scrubber"
-	"github.com/shshwtsuthar/recall/pipes/transmitter"
 	"github.com/shshwtsuthar/recall/source"
 )
 
@@ -17,82 +25,235 @@ import (
 type Config struct {
 	// ServerURL is the hive mind ingest endpoint.
 	// Example: "https://hivemind.yourdomain.com/ingest"
+	// If empty, the default chain keeps messages in the local store
+	// instead (the "store" stage).
 	ServerURL string
 
+	// DryRun replaces the stages that send messages off the machine with
+	// "dry-run", which writes the payloads to DryRunOutput (a file path;
+	// stderr if empty) instead, and summarizes scrub hits and drops at the
+	// end. Nothing is sent or stored for upload.
+	DryRun       bool
+	DryRunOutput string
+
+	// LocalMaxBytes caps the local store's size; 0 means
+	// localstore.DefaultMaxBytes.
+	LocalMaxBytes int64
+
 	// EnvSecrets is a map of environment variable name → value.
 	// Any occurrence of these values in messages will be scrubbed.
 	// Example: {"DATABASE_URL": "postgres://...", "API_KEY": "sk-..."}
 	EnvSecrets map[string]string
+
+	// Stages names the processing chain, in order (see Stage). Empty means
+	// DefaultStages(config).
+	Stages []string
+
+	// GitLink links sessions that have a workspace to the commits made in
+	// it (see package gitlink). GitHook additionally installs the
+	// prepare-commit-msg hook that writes the Recall-Session trailer.
+	GitLink bool
+	GitHook bool
+
+	// ShutdownTimeout bounds each phase of shutdown: processing what the
+	// sources had already produced, then flushing in-flight sends.
+	// Defaults to DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+
+	// OptOut lists the workspaces, remotes and files never to capture, on
+	// top of those marked with a .recallignore (see package optout).
+	OptOut optout.Config
+
+	// Pause, if set, lets capture be paused and resumed at runtime (see
+	// package pause).
+	Pause *pause.Switch
+
+	// Directives, if set, is the prefix of the in-band directive lines
+	// ("#recall off") honored in prompts (see package directive).
+	Directives string
+
+	// Scrub, if set, adds to and changes the built-in scrub rules (see
+	// package scrubber).
+	Scrub *scrubber.Config
+
+	// Filter, if set, drops messages by rule and samples sessions before
+	// anything is scrubbed or sent (see package filter).
+	Filter *filter.Config
+
+	// MetricsAddr, if set, serves Prometheus metrics at /metrics on this
+	// localhost address. MetricsSummary, if positive, prints a one-line
+	// status summary to stderr at that interval.
+	MetricsAddr    string
+	MetricsSummary time.Duration
+
+	// Retention labels sessions with how many of the agent's proposed edits
+	// were kept, evaluated RetentionDelay after the session goes quiet or at
+	// the next commit (see package retention).
+	Retention      bool
+	RetentionDelay time.Duration
+
+	// BlobURL, if set, is the server's blob endpoint: message values of at
+	// least BlobMinBytes bytes are then uploaded there once and referenced
+	// by hash (see transmitter.UseBlobStore). Zero BlobMinBytes means
+	// transmitter.DefaultBlobMinBytes.
+	BlobURL      string
+	BlobMinBytes int
+
+	// NoEnrich leaves the "enrich" stage out of the default chain, so
+	// messages carry no machine, agent or repository labels.
+	NoEnrich bool
+
+	// ProbeAgentVersion lets the "enrich" stage run `<agent> --version` to
+	// label messages with the agent's version.
+	ProbeAgentVersion bool
+
+	// HashSalt is mixed into the digests the "enrich" stage sends (see
+	// package enrich).
+	HashSalt string
 }
 
-// Run consumes messages from a source, scrubs them, and transmits to the server.
-// It blocks until the source completes or ctx is cancelled.
+// DefaultShutdownTimeout is used when Config.ShutdownTimeout is zero.
+const DefaultShutdownTimeout = 5 * time.Second
+
+// Run consumes messages from one or more sources and passes them through the
+// configured stages (by default: scrub, then transmit to the server). It
+// blocks until every source completes or ctx is cancelled.
 //
 // Architecture:
-//  1. Creates a buffered message channel (prevents source blocking)
-//  2. Spawns source.Run() in a goroutine (produces messages)
-//  3. Consumes messages in a loop, applying scrubbing and transmission
-//  4. Drains remaining messages after source signals completion
-//  5. Returns the source's error status
+//  1. Gives each source its own channel (each source owns and closes its channel)
+//  2. Spawns every source.Run() in a goroutine, plus a forwarder that fans its
+//     channel into one buffered message channel
+//  3. Consumes merged messages in a loop, passing each through the stage chain
+//  4. Returns once all sources have finished and their messages are processed
+//
+// Shutdown is orderly: when ctx is cancelled the sources stop (they see the
+// same ctx), and the messages they already produced are still processed
+// until every source has closed its channel or ShutdownTimeout passes. The
+// stages are then closed with a fresh ShutdownTimeout, which lets "send"
+// flush in-flight transmissions. Counts of drained and dropped messages are
+// reported on stderr.
+//
+// Sources fail independently: one source returning an error is logged and
+// the others keep running. The returned error joins every source's error.
 //
-// The pipeline never blocks the source. If transmission is slow, messages
+// The pipeline never blocks the sources. If transmission is slow, messages
 // queue in the channel buffer. Transmitter.Send() is async (fire-and-forget),
 // so transmission latency never stalls message processing.
-func Run(ctx context.Context, src source.Source, config Config) error {
-	// Buffered channel prevents source from blocking if pipeline is busy.
+func Run(ctx context.Context, config Config, sources ...source.Source) error {
+	// Buffered channel prevents sources from blocking if pipeline is busy.
 	// 100 messages is generous for typical ACP traffic (1-5 messages/sec).
 	messages := make(chan source.Message, 100)
+	depth := func() int { return len(messages) }
+	queueLen.Store(&depth)
 
-	// Create transmitter with source name (used in server payloads).
-	tx := transmitter.New(config.ServerURL, src.Name())
+	stopMetrics, err := startMetrics(config)
+	if err != nil {
+		return err
+	}
+	defer stopMetrics()
+
+	// Build the stage chain: the configured stages, or the default
+	// scrub → scrub-env → enrich → send (plus any enabled observers).
+	names := config.Stages
+	if len(names) == 0 {
+		names = DefaultStages(config)
+	}
+	if config.DryRun {
+		names = dryRunChain(names)
+	}
+	stages, err := newChain(config, names, sources)
+	if err != nil {
+		return err
+	}
+	timeout := config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		stages.close(closeCtx)
+	}()
 
-	// Start source in background goroutine.
-	// The source owns the channel and will close it when done.
-	sourceErr := make(chan error, 1)
+	// Start every source in the background with its own channel. stop tells
+	// the forwarders that Run returned and nobody reads messages any more.
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(sources))
+		stop = make(chan struct{})
+	)
+	defer close(stop)
+	for i, src := range sources {
+		own := make(chan source.Message, cap(messages))
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := src.Run(ctx, own); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", src.Name(), err)
+				if ctx.Err() == nil {
+					fmt.Fprintf(os.Stderr, "[recall] source %s stopped: %v\n", src.Name(), err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for msg := range own {
+				select {
+				case messages <- msg:
+				case <-stop:
+					// Discard the rest so the source can still finish.
+					for range own {
+					}
+					return
+				}
+			}
+		}()
+	}
 	go func() {
-		sourceErr <- src.Run(ctx, messages)
+		wg.Wait()
+		close(messages)
 	}()
 
-	// Consume messages until source completes or context is cancelled.
+	// Consume messages until every source completes. Once ctx is cancelled,
+	// keep draining until the sources have closed or the deadline passes.
+	var (
+		done     = ctx.Done()
+		deadline <-chan time.Time
+		drained  int
+	)
 	for {
 		select {
-		case <-ctx.Done():
-			// Context cancelled (e.g., SIGINT). Stop immediately.
-			return ctx.Err()
+		case <-done:
+			// Context cancelled (e.g., SIGINT). Stop intake: sources see the
+			// same cancellation and close their channels.
+			done = nil
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			deadline = timer.C
 
-		case err := <-sourceErr:
-			// Source completed (success or failure).
-			// Drain any remaining messages in the channel before exiting.
-			for msg := range messages {
-				processMessage(msg, tx, config.EnvSecrets)
-			}
-			return err
+		case <-deadline:
+			// Some source didn't stop in time. What is still buffered is
+			// unscrubbed, so it can't be kept: drop it.
+			dropped := len(messages)
+			droppedTotal.Add(float64(dropped), "shutdown")
+			fmt.Fprintf(os.Stderr, "[recall] shutdown: processed %d queued message(s), dropped at least %d\n", drained, dropped)
+			return ctx.Err()
 
 		case msg, ok := <-messages:
 			if !ok {
-				// Channel closed by source — it's done producing.
-				// Wait for the source error and return it.
-				return <-sourceErr
+				// Every source has closed its channel and returned.
+				if deadline != nil {
+					fmt.Fprintf(os.Stderr, "[recall] shutdown: processed %d queued message(s), dropped 0\n", drained)
+					return ctx.Err()
+				}
+				return errors.Join(errs...)
 			}
-			processMessage(msg, tx, config.EnvSecrets)
+			if deadline != nil {
+				drained++
+			}
+			messagesTotal.Inc(msg.SourceName, msg.Direction)
+			messageBytes.Add(float64(len(msg.Raw)), msg.SourceName, msg.Direction)
+			stages.submit(0, msg)
 		}
 	}
 }
-
-// processMessage applies the scrubbing pipeline and transmits the result.
-// This function is called for every message, regardless of source type.
-func processMessage(msg source.Message, tx *transmitter.Client, envSecrets map[string]string) {
-	// Apply the full scrubbing pipeline.
-	scrubbed := scrubber.Scrub(msg.Raw)
-	if len(envSecrets) > 0 {
-		scrubbed = scrubber.ScrubEnvVars(scrubbed, envSecrets)
-	}
-
-	// Transmit scrubbed message (async, fire-and-forget).
-	// If transmission fails, transmitter logs to stderr but never blocks us.
-	tx.Send(msg.Direction, msg.SessionID, scrubbed)
-
-	// Note: We DO NOT forward messages here. That's the source's job.
-	// Sources handle forwarding because they know the destination (stdio, file, etc.).
-	// The pipeline only processes — it never performs I/O except transmission.
-}
@@ -0,0 +1,300 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/shshwtsuthar/recall/source"
+)
+
+// Stage is one step of the processing chain every message goes through.
+//
+// Process returns the messages to hand to the next stage: the message itself
+// (pass-through, or transformed/enriched), nothing (filtered out), or several
+// (fan-out). Sinks — stages that deliver messages somewhere, like "send" —
+// pass the message on as well, so several sinks can be chained.
+//
+// Process is never called concurrently within one chain.
+type Stage interface {
+	Process(msg source.Message) []source.Message
+}
+
+// StageFunc adapts a function to the Stage interface.
+type StageFunc func(msg source.Message) []source.Message
+
+// Process calls f(msg).
+func (f StageFunc) Process(msg source.Message) []source.Message {
+	return f(msg)
+}
+
+// Closer is implemented by stages that need to finish work when the
+// pipeline stops. Stages are closed in chain order after the last message,
+// so a closing stage may still Emit into the stages after it. ctx carries
+// the shutdown deadline (Config.ShutdownTimeout).
+type Closer interface {
+	Close(ctx context.Context)
+}
+
+// Holder is implemented by stages that keep messages back until they can
+// decide on them, like optout while it looks a workspace up. Process
+// returns nothing for a message it holds; Held, the number of messages held
+// so far, lets the chain tell those from dropped ones. Once the stage calls
+// StageEnv.Wake, the chain calls Release, which returns, in order, what
+// Process would have returned for each held message that may now go on.
+type Holder interface {
+	Held() int
+	Release() [][]source.Message
+}
+
+// StageEnv is what a stage factory gets to build its stage.
+type StageEnv struct {
+	// Config is the pipeline configuration.
+	Config Config
+
+	// Emit hands a message the stage produced on its own (e.g. from a
+	// background goroutine or while closing) to the stages after it. It
+	// is safe to call from any goroutine, including from within Process.
+	Emit func(source.Message)
+
+	// Wake asks the chain to call the stage's Release (see Holder) after
+	// the messages queued so far. It is safe to call from any goroutine.
+	Wake func()
+
+	// Sources are the sources the pipeline is running.
+	Sources []source.Source
+}
+
+// StageFactory builds a stage. It runs once per pipeline.Run.
+type StageFactory func(env StageEnv) (Stage, error)
+
+var (
+	stagesMu       sync.RWMutex
+	stageFactories = make(map[string]StageFactory)
+)
+
+// RegisterStage makes a stage available by name for Config.Stages
+// (RECALL_STAGES). Like source.Register, it is intended to be called from an
+// init function and panics if the name is taken.
+func RegisterStage(name string, factory StageFactory) {
+	if name == "" || factory == nil {
+		panic("pipeline: RegisterStage requires a name and a factory")
+	}
+	stagesMu.Lock()
+	defer stagesMu.Unlock()
+	if _, dup := stageFactories[name]; dup {
+		panic("pipeline: RegisterStage called twice for " + name)
+	}
+	stageFactories[name] = factory
+}
+
+// RegisteredStages returns the names of all registered stages, sorted.
+func RegisteredStages() []string {
+	stagesMu.RLock()
+	defer stagesMu.RUnlock()
+	names := make([]string, 0, len(stageFactories))
+	for name := range stageFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultStages is the chain used when Config.Stages is empty: the
+// workspace opt-out, the optional prompt directives and the pause switch
+// (first, so what they drop reaches nothing else), the optional repository
+// observers (which need unscrubbed content), the optional filter (after the
+// observers, so it also applies to what they emit), then scrubbing, then
+// environment labels, then transmission — or, without a server URL, the
+// local store, and in a dry run, the dry-run output.
+func DefaultStages(config Config) []string {
+	names := []string{"optout"}
+	if config.Directives != "" {
+		names = append(names, "directives")
+	}
+	if config.Pause != nil {
+		names = append(names, "pause")
+	}
+	if config.GitLink {
+		names = append(names, "gitlink")
+	}
+	if config.Retention {
+		names = append(names, "retention")
+	}
+	if config.Filter != nil {
+		names = append(names, "filter")
+	}
+	names = append(names, "scrub", "scrub-env")
+	if !config.NoEnrich {
+		names = append(names, "enrich")
+	}
+	switch {
+	case config.DryRun:
+		return append(names, "dry-run")
+	case config.ServerURL == "":
+		return append(names, "store")
+	}
+	return append(names, "send")
+}
+
+// chain runs messages through an ordered list of stages.
+//
+// Messages enter either from the pipeline loop or through a stage's Emit,
+// possibly from another goroutine. Both go through a queue that is drained
+// by whichever caller holds the processing lock, which keeps Process calls
+// serialized and lets Process itself Emit without deadlocking.
+type chain struct {
+	names  []string
+	stages []Stage
+
+	processing sync.Mutex
+
+	queueMu sync.Mutex
+	queue   []queued
+}
+
+// queued is a message waiting to enter the chain at stage index at, or,
+// with release set, a Holder stage at index at waiting to be released.
+type queued struct {
+	at      int
+	msg     source.Message
+	release bool
+}
+
+// newChain builds the named stages in order.
+func newChain(config Config, names []string, sources []source.Source) (*chain, error) {
+	c := &chain{names: names}
+
+	// Stages may Emit as soon as they are built (e.g. evaluations left over
+	// from the last run); hold those back until the whole chain exists.
+	c.processing.Lock()
+	err := c.build(config, sources)
+	c.processing.Unlock()
+	if err != nil {
+		c.close(context.Background())
+		return nil, err
+	}
+	c.kick()
+	return c, nil
+}
+
+// build creates the stages. The caller holds c.processing.
+func (c *chain) build(config Config, sources []source.Source) error {
+	for i, name := range c.names {
+		stagesMu.RLock()
+		factory, ok := stageFactories[name]
+		stagesMu.RUnlock()
+		if !ok {
+			return fmt.Errorf("unknown pipeline stage %q (known: %v)", name, RegisteredStages())
+		}
+		at := i
+		stage, err := factory(StageEnv{
+			Config:  config,
+			Emit:    func(msg source.Message) { c.submit(at+1, msg) },
+			Wake:    func() { c.enqueue(queued{at: at, release: true}) },
+			Sources: sources,
+		})
+		if err != nil {
+			return fmt.Errorf("stage %s: %w", name, err)
+		}
+		c.stages = append(c.stages, stage)
+	}
+	return nil
+}
+
+// submit queues msg to enter the chain at stage at and processes it.
+func (c *chain) submit(at int, msg source.Message) {
+	c.enqueue(queued{at: at, msg: msg})
+}
+
+// enqueue adds q to the queue and drains it.
+func (c *chain) enqueue(q queued) {
+	c.queueMu.Lock()
+	c.queue = append(c.queue, q)
+	c.queueMu.Unlock()
+	c.kick()
+}
+
+// kick drains the queue unless another caller is already draining it.
+func (c *chain) kick() {
+	for c.processing.TryLock() {
+		c.drain()
+		c.processing.Unlock()
+
+		// Something may have been queued between our last look and the
+		// unlock, while its submitter's TryLock was failing.
+		c.queueMu.Lock()
+		empty := len(c.queue) == 0
+		c.queueMu.Unlock()
+		if empty {
+			return
+		}
+	}
+}
+
+// drain processes queued messages until the queue is empty. The caller
+// holds c.processing.
+func (c *chain) drain() {
+	for {
+		c.queueMu.Lock()
+		if len(c.queue) == 0 {
+			c.queueMu.Unlock()
+			return
+		}
+		next := c.queue[0]
+		c.queue = c.queue[1:]
+		c.queueMu.Unlock()
+
+		if next.release {
+			c.release(next.at)
+		} else {
+			c.run(next.at, next.msg)
+		}
+	}
+}
+
+// run passes msg through the stages from index at to the end.
+func (c *chain) run(at int, msg source.Message) {
+	if at >= len(c.stages) {
+		return
+	}
+	stage := c.stages[at]
+	holder, holds := stage.(Holder)
+	held := 0
+	if holds {
+		held = holder.Held()
+	}
+	outs := stage.Process(msg)
+	if len(outs) == 0 && !(holds && holder.Held() > held) {
+		droppedTotal.Inc(c.names[at])
+	}
+	for _, out := range outs {
+		c.run(at+1, out)
+	}
+}
+
+// release passes on what the Holder stage at index at lets go of.
+func (c *chain) release(at int) {
+	holder, ok := c.stages[at].(Holder)
+	if !ok {
+		return
+	}
+	for _, outs := range holder.Release() {
+		if len(outs) == 0 {
+			droppedTotal.Inc(c.names[at])
+		}
+		for _, out := range outs {
+			c.run(at+1, out)
+		}
+	}
+}
+
+// close closes the stages that implement Closer, in chain order.
+func (c *chain) close(ctx context.Context) {
+	for _, stage := range c.stages {
+		if closer, ok := stage.(Closer); ok {
+			closer.Close(ctx)
+		}
+	}
+}
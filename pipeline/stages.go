@@ -0,0 +1,301 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/shshwtsuthar/recall/internal/appdir"
+	"github.com/shshwtsuthar/recall/pipes/directive"
+	"github.com/shshwtsuthar/recall/pipes/enrich"
+	"github.com/shshwtsuthar/recall/pipes/filter"
+	"github.com/shshwtsuthar/recall/pipes/gitlink"
+	"github.com/shshwtsuthar/recall/pipes/localstore"
+	"github.com/shshwtsuthar/recall/pipes/optout"
+	"github.com/shshwtsuthar/recall/pipes/retention"
+	"github.com/shshwtsuthar/recall/pipes/scrubber"
+	"github.com/shshwtsuthar/recall/pipes/transmitter"
+	"github.com/shshwtsuthar/recall/source"
+)
+
+// The built-in stages. DefaultStages composes them into the standard chain.
+func init() {
+	// scrub replaces structured secrets (API keys, tokens, paths) with
+	// typed placeholders, by the built-in rules and RECALL_SCRUB_RULES.
+	RegisterStage("scrub", func(env StageEnv) (Stage, error) {
+		s, err := newScrubber(env.Config)
+		if err != nil {
+			return nil, err
+		}
+		return StageFunc(func(msg source.Message) []source.Message {
+			msg.Raw = s.ScrubHits(msg.Raw, countScrubHit)
+			return []source.Message{msg}
+		}), nil
+	})
+
+	// scrub-env replaces the values of the RECALL_SECRETS env vars.
+	RegisterStage("scrub-env", func(env StageEnv) (Stage, error) {
+		secrets := env.Config.EnvSecrets
+		return StageFunc(func(msg source.Message) []source.Message {
+			if len(secrets) > 0 {
+				msg.Raw = scrubber.ScrubEnvVarsHits(msg.Raw, secrets, func(name string, n int) {
+					countScrubHit("<ENV:"+name+">", n)
+				})
+			}
+			return []source.Message{msg}
+		}), nil
+	})
+
+	// optout drops sessions in ignored workspaces and reads of ignored files.
+	RegisterStage("optout", func(env StageEnv) (Stage, error) {
+		o, err := optout.New(env.Config.OptOut)
+		if err != nil {
+			return nil, err
+		}
+		o.Attach(env.Wake)
+		return o, nil
+	})
+
+	// directives honors "#recall off|on|tag" lines typed in prompts.
+	RegisterStage("directives", func(env StageEnv) (Stage, error) {
+		return directive.New(env.Config.Directives, env.Config.Pause), nil
+	})
+
+	// pause drops the messages of paused sessions and marks the gaps.
+	RegisterStage("pause", func(env StageEnv) (Stage, error) {
+		if env.Config.Pause == nil {
+			return nil, fmt.Errorf("no pause switch configured")
+		}
+		env.Config.Pause.Attach(env.Emit)
+		return env.Config.Pause, nil
+	})
+
+	// filter drops messages by rule and samples sessions.
+	RegisterStage("filter", func(env StageEnv) (Stage, error) {
+		if env.Config.Filter == nil {
+			return nil, fmt.Errorf("needs RECALL_FILTER_FILE or RECALL_SAMPLE_PERCENT")
+		}
+		f, err := filter.New(*env.Config.Filter)
+		if err != nil {
+			return nil, err
+		}
+		return StageFunc(func(msg source.Message) []source.Message {
+			if !f.Keep(msg) {
+				return nil
+			}
+			return []source.Message{msg}
+		}), nil
+	})
+
+	// enrich labels messages with the (hashed) machine, platform, recall
+	// build, agent and repository they come from.
+	RegisterStage("enrich", func(env StageEnv) (Stage, error) {
+		agents := make(map[string][]string)
+		for _, src := range env.Sources {
+			if l, ok := src.(source.Launcher); ok {
+				agents[src.Name()] = l.AgentCommand()
+			}
+		}
+		e := enrich.New(enrich.Config{
+			Agents:       agents,
+			ProbeVersion: env.Config.ProbeAgentVersion,
+			Salt:         env.Config.HashSalt,
+		})
+		return StageFunc(func(msg source.Message) []source.Message {
+			return []source.Message{e.Enrich(msg)}
+		}), nil
+	})
+
+	// send transmits to the ingest server (async, fire-and-forget). If
+	// transmission fails, the transmitter logs to stderr but never blocks us.
+	RegisterStage("send", newSendStage)
+
+	// store keeps messages in the local store, for recall-proxy upload.
+	RegisterStage("store", newStoreStage)
+
+	// dry-run writes what would be sent to stderr or a file, for auditing.
+	RegisterStage("dry-run", newDryRunStage)
+
+	// gitlink links sessions to the commits made in their workspace.
+	RegisterStage("gitlink", func(env StageEnv) (Stage, error) {
+		return &observerStage{gitlink.New(gitlink.Config{
+			InstallHook: env.Config.GitHook,
+			Emit:        observerEmit(env),
+		})}, nil
+	})
+
+	// retention labels sessions with how many proposed edits were kept.
+	RegisterStage("retention", func(env StageEnv) (Stage, error) {
+		stateDir, err := appdir.Path("retention")
+		if err != nil {
+			return nil, err
+		}
+		tracker, err := retention.New(retention.Config{
+			StateDir: stateDir,
+			Delay:    env.Config.RetentionDelay,
+			Emit:     observerEmit(env),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &observerStage{tracker}, nil
+	})
+}
+
+// newScrubber builds the scrubber for config's rules.
+func newScrubber(config Config) (*scrubber.Scrubber, error) {
+	if config.Scrub == nil {
+		return scrubber.New(scrubber.Config{})
+	}
+	return scrubber.New(*config.Scrub)
+}
+
+// countScrubHit records scrubber replacements in the metrics.
+func countScrubHit(rule string, n int) {
+	scrubHits.Add(float64(n), rule)
+}
+
+// observer is a component that watches messages and emits its own records
+// (through StageEnv.Emit) rather than changing the messages it sees.
+type observer interface {
+	Observe(msg source.Message)
+	Close()
+}
+
+// observerEmit returns the Emit for an observer. Its records enter the chain
+// after the pause stage, so the records of paused sessions are dropped here.
+func observerEmit(env StageEnv) func(source.Message) {
+	sw := env.Config.Pause
+	if sw == nil {
+		return env.Emit
+	}
+	return func(msg source.Message) {
+		if sw.Paused(msg.SessionID) {
+			return
+		}
+		env.Emit(msg)
+	}
+}
+
+// observerStage passes every message through after showing it to an observer.
+type observerStage struct {
+	observer observer
+}
+
+func (s *observerStage) Process(msg source.Message) []source.Message {
+	s.observer.Observe(msg)
+	return []source.Message{msg}
+}
+
+func (s *observerStage) Close(context.Context) {
+	s.observer.Close()
+}
+
+// sendStage transmits every message to the ingest server.
+//
+// On Close it waits for the sends still in flight; the ones that miss the
+// shutdown deadline are cancelled, spooled to disk and resent on the next
+// start that sends to the same server.
+type sendStage struct {
+	tx       *transmitter.Client
+	spoolDir string // where unsent payloads for this server are kept
+}
+
+func newSendStage(env StageEnv) (Stage, error) {
+	outbox, err := appdir.Path("outbox")
+	if err != nil {
+		return nil, err
+	}
+	spoolDir := transmitter.SpoolDir(outbox, env.Config.ServerURL)
+	s := &sendStage{tx: transmitter.New(env.Config.ServerURL), spoolDir: spoolDir}
+	if env.Config.BlobURL != "" {
+		s.tx.UseBlobStore(env.Config.BlobURL, env.Config.BlobMinBytes)
+	}
+	if n, err := s.tx.ResendSpool(spoolDir); err != nil {
+		fmt.Fprintf(os.Stderr, "[recall/transmit] %v\n", err)
+	} else if n > 0 {
+		fmt.Fprintf(os.Stderr, "[recall/transmit] resending %d message(s) saved at last shutdown\n", n)
+	}
+	return s, nil
+}
+
+func (s *sendStage) Process(msg source.Message) []source.Message {
+	s.tx.Send(toPayload(msg))
+	return []source.Message{msg}
+}
+
+// toPayload converts a message to what the server receives.
+func toPayload(msg source.Message) transmitter.Payload {
+	payload := transmitter.Payload{
+		Direction:  msg.Direction,
+		Raw:        msg.Raw,
+		SessionID:  msg.SessionID,
+		SourceName: msg.SourceName,
+		Meta:       msg.Meta,
+	}
+	if !msg.CapturedAt.IsZero() {
+		payload.CapturedAt = msg.CapturedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return payload
+}
+
+func (s *sendStage) Close(ctx context.Context) {
+	stats := s.tx.Close(ctx)
+	if stats.InFlight == 0 {
+		return
+	}
+	saved := 0
+	if len(stats.Unsent) > 0 {
+		if err := transmitter.SaveSpool(s.spoolDir, stats.Unsent); err != nil {
+			fmt.Fprintf(os.Stderr, "[recall/transmit] %v\n", err)
+		} else {
+			saved = len(stats.Unsent)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "[recall/transmit] shutdown: %d in flight — %d flushed, %d failed, %d saved for next start, %d lost\n",
+		stats.InFlight, stats.Flushed, stats.Failed, saved, len(stats.Unsent)-saved)
+}
+
+// storeStage writes every message, stamped as the send stage would, to the
+// local store (see package localstore).
+type storeStage struct {
+	store   *localstore.Store
+	stamper *transmitter.Stamper
+	failing bool // a write error was reported and no write succeeded since
+}
+
+func newStoreStage(env StageEnv) (Stage, error) {
+	dir, err := LocalStoreDir()
+	if err != nil {
+		return nil, err
+	}
+	store, err := localstore.Open(localstore.Config{Dir: dir, MaxBytes: env.Config.LocalMaxBytes})
+	if err != nil {
+		return nil, err
+	}
+	return &storeStage{store: store, stamper: transmitter.NewStamper()}, nil
+}
+
+// LocalStoreDir returns the directory of the local store.
+func LocalStoreDir() (string, error) {
+	return appdir.Path("sessions")
+}
+
+func (s *storeStage) Process(msg source.Message) []source.Message {
+	if err := s.store.Write(s.stamper.Stamp(toPayload(msg))); err != nil {
+		if !s.failing {
+			fmt.Fprintf(os.Stderr, "[recall/local] %v\n", err)
+		}
+		s.failing = true
+	} else {
+		s.failing = false
+	}
+	return []source.Message{msg}
+}
+
+func (s *storeStage) Close(context.Context) {
+	if err := s.store.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "[recall/local] %v\n", err)
+	}
+}
@@ -0,0 +1,73 @@
+package pipeline
+
+import (
+	"context"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shshwtsuthar/recall/pipes/pause"
+	"github.com/shshwtsuthar/recall/source"
+)
+
+func TestPausedSessionEmitsNoGitRecords(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=t", "-c", "user.email=t@t"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "first")
+
+	var (
+		mu  sync.Mutex
+		got []source.Message
+	)
+	RegisterStage("test-collect", func(StageEnv) (Stage, error) {
+		return StageFunc(func(msg source.Message) []source.Message {
+			mu.Lock()
+			got = append(got, msg)
+			mu.Unlock()
+			return []source.Message{msg}
+		}), nil
+	})
+	gitRecords := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		n := 0
+		for _, msg := range got {
+			if msg.Direction == "git" {
+				n++
+			}
+		}
+		return n
+	}
+
+	sw := pause.NewSwitch()
+	c, err := newChain(Config{Pause: sw}, []string{"pause", "gitlink", "test-collect"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.submit(0, source.Message{Raw: "{}", Direction: "upstream", SessionID: "s", Workspace: repo})
+	for deadline := time.Now().Add(5 * time.Second); gitRecords() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("session_start was not emitted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sw.Pause("s")
+	git("commit", "-q", "--allow-empty", "-m", "secret subject")
+	c.close(context.Background())
+
+	if n := gitRecords(); n != 1 {
+		t.Errorf("got %d git records, want only session_start", n)
+	}
+}
@@ -0,0 +1,99 @@
+// Package directive honors the directive lines developers type at the
+// start of an ACP prompt ("#recall off", "#recall on", "#recall tag
+// <label>"; see package prompt for the syntax).
+//
+// Directives are honored by the "directives" pipeline stage (see Handler).
+// Optionally, the ACP source also strips them from the prompt before it
+// reaches the agent (see prompt.Strip) — the only place recall modifies
+// traffic.
+package directive
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/shshwtsuthar/recall/internal/prompt"
+	"github.com/shshwtsuthar/recall/pipes/pause"
+	"github.com/shshwtsuthar/recall/source"
+)
+
+// Handler applies directives to the pause switch and the session's tags.
+// Its methods are not safe for concurrent use; the pipeline calls them
+// from one goroutine.
+type Handler struct {
+	prefix string
+	pause  *pause.Switch // nil: off and on are ignored
+	tags   map[string][]string
+}
+
+// New returns a Handler for directives starting with prefix
+// (prompt.DefaultPrefix if empty), pausing and resuming through sw.
+func New(prefix string, sw *pause.Switch) *Handler {
+	if prefix == "" {
+		prefix = prompt.DefaultPrefix
+	}
+	return &Handler{prefix: prefix, pause: sw, tags: make(map[string][]string)}
+}
+
+// Process implements the pipeline stage. It applies the directives in msg,
+// adds the session's tags to msg.Meta as "tags" (comma-separated), and
+// follows a tag directive with a "control" record naming the new label.
+func (h *Handler) Process(msg source.Message) []source.Message {
+	var records []source.Message
+	if msg.Direction == "upstream" {
+		sessionID, directives := prompt.Find(h.prefix, msg.Raw)
+		if sessionID == "" {
+			sessionID = msg.SessionID
+		}
+		for _, d := range directives {
+			switch {
+			case sessionID == "":
+				fmt.Fprintf(os.Stderr, "[recall/directive] ignoring %s %s: no session\n", h.prefix, d.Verb)
+			case d.Verb == prompt.Tag:
+				if h.addTag(sessionID, d.Label) {
+					raw, _ := json.Marshal(map[string]string{"type": "session_tagged", "label": d.Label})
+					records = append(records, source.Message{
+						Raw:        string(raw),
+						Direction:  "control",
+						SessionID:  sessionID,
+						SourceName: msg.SourceName,
+						CapturedAt: msg.CapturedAt,
+					})
+				}
+			case h.pause == nil:
+				fmt.Fprintf(os.Stderr, "[recall/directive] ignoring %s %s: pausing is not available\n", h.prefix, d.Verb)
+			case d.Verb == prompt.Off:
+				h.pause.Pause(sessionID)
+			case d.Verb == prompt.On:
+				h.pause.Resume(sessionID)
+			}
+		}
+	}
+
+	out := append([]source.Message{msg}, records...)
+	for i := range out {
+		if tags := h.tags[out[i].SessionID]; len(tags) > 0 && out[i].SessionID != "" {
+			meta := make(map[string]string, len(out[i].Meta)+1)
+			for k, v := range out[i].Meta {
+				meta[k] = v
+			}
+			meta["tags"] = strings.Join(tags, ",")
+			out[i].Meta = meta
+		}
+	}
+	return out
+}
+
+// addTag adds label to the session's tags and reports whether it was new.
+func (h *Handler) addTag(sessionID, label string) bool {
+	tags := h.tags[sessionID]
+	i := sort.SearchStrings(tags, label)
+	if i < len(tags) && tags[i] == label {
+		return false
+	}
+	h.tags[sessionID] = append(tags[:i], append([]string{label}, tags[i:]...)...)
+	return true
+}
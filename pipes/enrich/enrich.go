@@ -0,0 +1,331 @@
+// Package enrich describes the environment a message was captured in, so
+// the server can slice trajectories by machine, platform, recall build,
+// agent and repository.
+//
+// Everything that could identify a person or a project is hashed before it
+// leaves the machine: the machine id, the git remote and the branch are sent
+// as truncated SHA-256 digests. They group messages ("same repo", "same
+// machine") without revealing the underlying value. The rest — OS,
+// architecture, recall version, agent name and version — is sent as is.
+//
+// Digests are salted with Config.Salt. Without a salt they are only
+// namespaced by recall and the kind of value, so anyone who can guess a
+// value (a public repository's URL, a branch called "main") can confirm it;
+// a deployment that wants its digests unguessable sets a secret salt, the
+// same on every machine so they still group across machines.
+//
+// The repository fields are looked up in the background: messages from a
+// workspace captured before its first lookup finishes go without them.
+//
+// The keys added to source.Message.Meta are:
+//
+//	machine_id      hashed OS machine id (or a random id kept in the app dir)
+//	os, arch        runtime.GOOS and runtime.GOARCH
+//	recall_version  release version of this binary
+//	recall_commit   VCS revision this binary was built from
+//	agent           agent binary name, for sources that launch the agent
+//	agent_version   first line of `<agent> --version`, if Config.ProbeVersion
+//	repo            hashed, normalized URL of the workspace's git remote
+//	branch          hashed name of the workspace's current branch
+//
+// Keys whose value is unknown are omitted.
+package enrich
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shshwtsuthar/recall/internal/appdir"
+	"github.com/shshwtsuthar/recall/internal/buildinfo"
+	"github.com/shshwtsuthar/recall/internal/git"
+	"github.com/shshwtsuthar/recall/source"
+)
+
+// workspaceTTL is how long a workspace's remote and branch are cached
+// before git is asked again (the branch changes under a running session).
+const workspaceTTL = time.Minute
+
+// probeTimeout bounds the `<agent> --version` and machine ID probes.
+const probeTimeout = 5 * time.Second
+
+// launchers are commands that run another program named by their first
+// non-flag argument. The agent is that program; it is not probed for its
+// version, since that could mean downloading it.
+var launchers = map[string]bool{"npx": true, "bunx": true, "uvx": true, "pipx": true, "pnpx": true}
+
+// Config configures an Enricher.
+type Config struct {
+	// Agents maps a source name to the agent command that source launches.
+	// Messages from other sources get no agent fields.
+	Agents map[string][]string
+
+	// ProbeVersion runs `<agent> --version` once at start to fill in
+	// agent_version. Off by default, since it starts the agent binary an
+	// extra time.
+	ProbeVersion bool
+
+	// Salt is mixed into every digest. See the package documentation.
+	Salt string
+}
+
+// Enricher computes the metadata for messages. It is safe for concurrent use.
+type Enricher struct {
+	static map[string]string // fields that are the same for every message
+	salt   string
+
+	mu         sync.Mutex
+	agents     map[string]agentInfo     // by source name
+	workspaces map[string]workspaceInfo // by workspace path
+	refreshing map[string]bool          // workspaces being looked up
+}
+
+type agentInfo struct {
+	name, version string
+}
+
+type workspaceInfo struct {
+	repo, branch string
+	checked      time.Time
+}
+
+// New gathers the machine-wide fields and, if configured, starts probing
+// agent versions in the background; until a probe finishes, its
+// agent_version is omitted.
+func New(config Config) *Enricher {
+	e := &Enricher{
+		static: map[string]string{
+			"os":             runtime.GOOS,
+			"arch":           runtime.GOARCH,
+			"recall_version": buildinfo.ReleaseVersion(),
+		},
+		salt:       config.Salt,
+		agents:     make(map[string]agentInfo),
+		workspaces: make(map[string]workspaceInfo),
+		refreshing: make(map[string]bool),
+	}
+	if commit := buildinfo.Commit(); commit != "" {
+		e.static["recall_commit"] = commit
+	}
+	if id, err := machineID(); err != nil {
+		fmt.Fprintf(os.Stderr, "[recall/enrich] machine id: %v\n", err)
+	} else {
+		e.static["machine_id"] = e.hash("machine", id)
+	}
+
+	for sourceName, command := range config.Agents {
+		name, probe := agentName(command)
+		if name == "" {
+			continue
+		}
+		e.agents[sourceName] = agentInfo{name: name}
+		if probe && config.ProbeVersion {
+			go e.probeVersion(sourceName, command[0])
+		}
+	}
+	return e
+}
+
+// Enrich returns msg with the metadata added to its Meta. Existing keys
+// are kept; the map is copied, never modified in place.
+func (e *Enricher) Enrich(msg source.Message) source.Message {
+	meta := make(map[string]string, len(msg.Meta)+len(e.static)+4)
+	for k, v := range e.static {
+		meta[k] = v
+	}
+
+	e.mu.Lock()
+	agent, ok := e.agents[msg.SourceName]
+	e.mu.Unlock()
+	if ok {
+		meta["agent"] = agent.name
+		if agent.version != "" {
+			meta["agent_version"] = agent.version
+		}
+	}
+
+	if msg.Workspace != "" {
+		ws := e.workspace(msg.Workspace)
+		if ws.repo != "" {
+			meta["repo"] = ws.repo
+		}
+		if ws.branch != "" {
+			meta["branch"] = ws.branch
+		}
+	}
+
+	for k, v := range msg.Meta {
+		meta[k] = v
+	}
+	msg.Meta = meta
+	return msg
+}
+
+// workspace returns the cached git details of dir, starting a background
+// refresh when they are missing or stale. It never runs git itself.
+func (e *Enricher) workspace(dir string) workspaceInfo {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ws, ok := e.workspaces[dir]
+	if (!ok || time.Since(ws.checked) >= workspaceTTL) && !e.refreshing[dir] {
+		e.refreshing[dir] = true
+		go e.refresh(dir)
+	}
+	return ws
+}
+
+// refresh reads the git details of dir into the cache.
+func (e *Enricher) refresh(dir string) {
+	ws := workspaceInfo{checked: time.Now()}
+	if remote := git.RemoteURL(dir); remote != "" {
+		ws.repo = e.hash("repo", git.NormalizeRemote(remote))
+	}
+	if branch, err := git.Run(dir, "symbolic-ref", "--quiet", "--short", "HEAD"); err == nil && branch != "" {
+		ws.branch = e.hash("branch", branch)
+	}
+
+	e.mu.Lock()
+	e.workspaces[dir] = ws
+	delete(e.refreshing, dir)
+	e.mu.Unlock()
+}
+
+// probeVersion runs `<binary> --version` and records the first line.
+func (e *Enricher) probeVersion(sourceName, binary string) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, binary, "--version")
+	out, err := cmd.Output()
+	if err != nil {
+		return
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	line = strings.TrimSpace(line)
+	if len(line) > 64 {
+		line = line[:64]
+	}
+	if line == "" {
+		return
+	}
+	e.mu.Lock()
+	agent := e.agents[sourceName]
+	agent.version = line
+	e.agents[sourceName] = agent
+	e.mu.Unlock()
+}
+
+// agentName returns the agent's binary name from its command line, and
+// whether it is safe to probe with --version.
+func agentName(command []string) (name string, probe bool) {
+	if len(command) == 0 {
+		return "", false
+	}
+	base := binaryName(command[0])
+	if !launchers[base] {
+		return base, true
+	}
+	for _, arg := range command[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			return binaryName(arg), false
+		}
+	}
+	return base, false
+}
+
+// binaryName strips the directory, a Windows extension and an npm version
+// suffix ("@scope/agent@1.2" → "@scope/agent").
+func binaryName(s string) string {
+	if !strings.HasPrefix(s, "@") {
+		s = filepath.Base(s)
+	}
+	s = strings.TrimSuffix(s, ".exe")
+	if i := strings.LastIndex(s, "@"); i > 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+// hash returns a truncated SHA-256 of value under the configured salt,
+// namespaced by kind so equal values of different kinds don't collide and
+// the digests don't match other tools'.
+func (e *Enricher) hash(kind, value string) string {
+	sum := sha256.Sum256([]byte("recall:" + e.salt + ":" + kind + ":" + value))
+	return hex.EncodeToString(sum[:8])
+}
+
+// machineID returns the OS's stable machine identifier, or a random one
+// generated once and kept in the app dir where the OS has none.
+func machineID() (string, error) {
+	if id := osMachineID(); id != "" {
+		return id, nil
+	}
+	path, err := appdir.Path("machine-id")
+	if err != nil {
+		return "", err
+	}
+	if data, err := os.ReadFile(path); err == nil && len(bytes.TrimSpace(data)) > 0 {
+		return string(bytes.TrimSpace(data)), nil
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+	if err := os.WriteFile(path, []byte(id+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("save %s: %w", path, err)
+	}
+	return id, nil
+}
+
+var (
+	ioregUUID   = regexp.MustCompile(`"IOPlatformUUID" = "([^"]+)"`)
+	machineGUID = regexp.MustCompile(`MachineGuid\s+REG_SZ\s+(\S+)`)
+)
+
+func osMachineID() string {
+	switch runtime.GOOS {
+	case "linux":
+		for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+			if data, err := os.ReadFile(path); err == nil {
+				if id := strings.TrimSpace(string(data)); id != "" {
+					return id
+				}
+			}
+		}
+	case "darwin":
+		if out, err := command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice"); err == nil {
+			if m := ioregUUID.FindStringSubmatch(out); m != nil {
+				return m[1]
+			}
+		}
+	case "windows":
+		if out, err := command("reg", "query", `HKLM\SOFTWARE\Microsoft\Cryptography`, "/v", "MachineGuid"); err == nil {
+			if m := machineGUID.FindStringSubmatch(out); m != nil {
+				return m[1]
+			}
+		}
+	}
+	return ""
+}
+
+// command runs a short-lived helper and returns its trimmed stdout.
+func command(name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w", name, strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
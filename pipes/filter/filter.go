@@ -0,0 +1,250 @@
+// Package filter decides which messages are transmitted at all, from
+// declarative rules and deterministic session sampling.
+//
+// Rules are read from a JSON file:
+//
+//	{
+//	  "sample_percent": 10,
+//	  "rules": [
+//	    {"action": "exclude", "method": "fs/read_text_file"},
+//	    {"action": "exclude", "direction": "model_request", "min_bytes": 100000},
+//	    {"action": "include", "session_update": "agent_message_chunk"},
+//	    {"action": "exclude", "regex": "\"internal-only\""}
+//	  ]
+//	}
+//
+// A message is first sampled: sessions are kept or dropped as a whole,
+// by hashing the session ID against sample_percent, so the same session is
+// always either captured in full or not at all. Messages without a session
+// ID are not sampled.
+//
+// The rules then run in order and the first one whose conditions all match
+// decides; a message no rule matches is included. A rule's conditions are:
+//
+//   - direction, source: exact match on the message's direction or source name
+//   - method: the JSON-RPC method of a request or notification, and also the
+//     responses to requests with that method (matched by id), so excluding
+//     fs/read_text_file drops the file contents the client sends back
+//   - session_update: the "sessionUpdate" kind of an ACP session/update
+//   - min_bytes, max_bytes: bounds on the raw message size
+//   - regex: a regular expression matched against the raw message
+package filter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+
+	"github.com/shshwtsuthar/recall/source"
+)
+
+// Actions a rule can take.
+const (
+	Include = "include"
+	Exclude = "exclude"
+)
+
+// maxTrackedRequests bounds the request-id → method table used to match
+// responses. Responses that never come would otherwise accumulate forever.
+const maxTrackedRequests = 10000
+
+// Config is the filter file's content.
+type Config struct {
+	// SamplePercent is the share of sessions to keep, 0–100. Nil keeps all.
+	SamplePercent *float64 `json:"sample_percent"`
+
+	// Rules are evaluated in order; the first match decides.
+	Rules []Rule `json:"rules"`
+}
+
+// Rule is one include/exclude rule. Empty conditions are ignored; at least
+// one must be set.
+type Rule struct {
+	Action        string `json:"action"`
+	Direction     string `json:"direction,omitempty"`
+	Source        string `json:"source,omitempty"`
+	Method        string `json:"method,omitempty"`
+	SessionUpdate string `json:"session_update,omitempty"`
+	MinBytes      int    `json:"min_bytes,omitempty"`
+	MaxBytes      int    `json:"max_bytes,omitempty"`
+	Regex         string `json:"regex,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Filter applies a Config. It is safe for concurrent use.
+type Filter struct {
+	config Config
+
+	mu       sync.Mutex
+	requests map[requestKey]string // in-flight request → its method
+}
+
+// requestKey identifies a JSON-RPC request within a session.
+type requestKey struct {
+	sessionID string
+	direction string // direction of the request, not the response
+	id        string
+}
+
+// Load reads a filter file. Unknown fields are errors; New validates the rest.
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("read filter file: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var config Config
+	if err := dec.Decode(&config); err != nil {
+		return Config{}, fmt.Errorf("parse filter file %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// New validates config and builds a Filter.
+func New(config Config) (*Filter, error) {
+	if p := config.SamplePercent; p != nil && (*p < 0 || *p > 100) {
+		return nil, fmt.Errorf("sample_percent must be between 0 and 100, got %v", *p)
+	}
+	for i := range config.Rules {
+		r := &config.Rules[i]
+		if r.Action != Include && r.Action != Exclude {
+			return nil, fmt.Errorf("rule %d: action must be %q or %q, got %q", i+1, Include, Exclude, r.Action)
+		}
+		if r.Direction == "" && r.Source == "" && r.Method == "" && r.SessionUpdate == "" &&
+			r.MinBytes == 0 && r.MaxBytes == 0 && r.Regex == "" {
+			return nil, fmt.Errorf("rule %d: no conditions (it would match every message)", i+1)
+		}
+		if r.MinBytes < 0 || r.MaxBytes < 0 || (r.MaxBytes > 0 && r.MinBytes > r.MaxBytes) {
+			return nil, fmt.Errorf("rule %d: invalid size bounds min_bytes=%d max_bytes=%d", i+1, r.MinBytes, r.MaxBytes)
+		}
+		if r.Regex != "" {
+			pattern, err := regexp.Compile(r.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid regex: %w", i+1, err)
+			}
+			r.pattern = pattern
+		}
+	}
+	return &Filter{config: config, requests: make(map[requestKey]string)}, nil
+}
+
+// Keep reports whether msg should be transmitted.
+func (f *Filter) Keep(msg source.Message) bool {
+	if !f.sampled(msg.SessionID) {
+		return false
+	}
+	if len(f.config.Rules) == 0 {
+		return true
+	}
+
+	fields := f.inspect(msg)
+	for _, r := range f.config.Rules {
+		if r.matches(msg, fields) {
+			return r.Action == Include
+		}
+	}
+	return true
+}
+
+// sampled reports whether the session falls within the sample.
+func (f *Filter) sampled(sessionID string) bool {
+	p := f.config.SamplePercent
+	if p == nil || sessionID == "" {
+		return true
+	}
+	return InSample(sessionID, *p)
+}
+
+// InSample reports whether sessionID falls within the first percent of the
+// hash space. The same session always gets the same answer, on every machine.
+func InSample(sessionID string, percent float64) bool {
+	sum := sha256.Sum256([]byte(sessionID))
+	bucket := binary.BigEndian.Uint64(sum[:8]) % 10000
+	return float64(bucket) < percent*100
+}
+
+// rpcFields are the protocol-level fields rules can match on.
+type rpcFields struct {
+	method        string
+	sessionUpdate string
+}
+
+// inspect extracts rpcFields, resolving a response's method from the request
+// it answers.
+func (f *Filter) inspect(msg source.Message) rpcFields {
+	var env struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal([]byte(msg.Raw), &env); err != nil {
+		return rpcFields{}
+	}
+	fields := rpcFields{method: env.Method}
+	if env.Method == "session/update" {
+		var params struct {
+			Update struct {
+				SessionUpdate string `json:"sessionUpdate"`
+			} `json:"update"`
+		}
+		if json.Unmarshal(env.Params, &params) == nil {
+			fields.sessionUpdate = params.Update.SessionUpdate
+		}
+	}
+	if env.ID == nil || !f.tracksMethods() {
+		return fields
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if env.Method != "" {
+		// A request: remember its method for the response.
+		if len(f.requests) >= maxTrackedRequests {
+			clear(f.requests)
+		}
+		f.requests[requestKey{msg.SessionID, msg.Direction, string(env.ID)}] = env.Method
+		return fields
+	}
+	// A response travels the opposite way to its request. A request sent
+	// before its session had an ID (session/new) was recorded without one.
+	for _, sessionID := range []string{msg.SessionID, ""} {
+		key := requestKey{sessionID, source.Opposite(msg.Direction), string(env.ID)}
+		if method, ok := f.requests[key]; ok {
+			fields.method = method
+			delete(f.requests, key)
+			break
+		}
+	}
+	return fields
+}
+
+// tracksMethods reports whether any rule needs responses resolved to methods.
+func (f *Filter) tracksMethods() bool {
+	for _, r := range f.config.Rules {
+		if r.Method != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matches(msg source.Message, fields rpcFields) bool {
+	switch {
+	case r.Direction != "" && r.Direction != msg.Direction,
+		r.Source != "" && r.Source != msg.SourceName,
+		r.Method != "" && r.Method != fields.method,
+		r.SessionUpdate != "" && r.SessionUpdate != fields.sessionUpdate,
+		r.MinBytes > 0 && len(msg.Raw) < r.MinBytes,
+		r.MaxBytes > 0 && len(msg.Raw) > r.MaxBytes,
+		r.pattern != nil && !r.pattern.MatchString(msg.Raw):
+		return false
+	}
+	return true
+}
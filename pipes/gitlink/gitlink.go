@@ -0,0 +1,417 @@
+// Package gitlink links agent sessions to the git commits they produce.
+//
+// For every session whose workspace is inside a git repository, the Tracker
+// records where HEAD was when the session started, polls for HEAD moves while
+// it runs, and describes the uncommitted working tree when it ends. Each
+// observation is emitted as a "git" message in the session, so it travels
+// through the same scrubbing and transmission as the conversation itself.
+//
+// Records are JSON objects with a "type" field:
+//
+//	{"type":"session_start","head":"<hash>","branch":"main"}
+//	{"type":"commit","commit":"<hash>","parents":[...],"subject":"...","files":[...],"trailer":true}
+//	{"type":"head_moved","from":"<hash>","to":"<hash>"}
+//	{"type":"session_end","head":"<hash>","files":[...],"untracked":[...]}
+//
+// "head_moved" is emitted instead of "commit" records when HEAD moves to
+// something that is not a descendant of where it was (reset, checkout).
+//
+// Optionally, the Tracker installs a prepare-commit-msg hook that appends a
+// "Recall-Session: <id>" trailer to commits made while a session is active,
+// so the link also survives in the repository's own history.
+package gitlink
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shshwtsuthar/recall/internal/git"
+	"github.com/shshwtsuthar/recall/source"
+)
+
+// DefaultPollInterval is how often HEAD is checked when Config.PollInterval is zero.
+const DefaultPollInterval = 5 * time.Second
+
+// TrailerKey is the commit trailer written by the prepare-commit-msg hook.
+const TrailerKey = "Recall-Session"
+
+// emptyTree is git's well-known empty tree, used to diff repositories that
+// have no commits yet.
+const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// Config holds Tracker configuration.
+type Config struct {
+	// InstallHook installs the prepare-commit-msg trailer hook in repositories
+	// that don't already have one.
+	InstallHook bool
+
+	// PollInterval is how often HEAD is checked. Defaults to DefaultPollInterval.
+	PollInterval time.Duration
+
+	// Emit receives every record. Calls are serialized.
+	Emit func(source.Message)
+}
+
+// Tracker follows the repositories of active sessions.
+type Tracker struct {
+	config Config
+
+	mu      sync.Mutex
+	seen    map[string]bool     // session IDs already considered
+	pending []source.Message    // first messages of sessions not yet looked at
+	active  map[string]*session // repository root → its active session
+	closed  bool
+
+	emitMu sync.Mutex
+	wake   chan struct{} // signals pending work to run
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// session is one agent session being tracked in one repository.
+type session struct {
+	mu         sync.Mutex // serializes polling with the session's end
+	id         string
+	sourceName string
+	root       string
+	head       string
+}
+
+// fileChange is one changed path in a commit or working tree.
+type fileChange struct {
+	Status  string `json:"status"`
+	Path    string `json:"path"`
+	Added   *int   `json:"added,omitempty"`
+	Deleted *int   `json:"deleted,omitempty"`
+}
+
+// record is the JSON body of every emitted message.
+type record struct {
+	Type      string       `json:"type"`
+	Head      string       `json:"head,omitempty"`
+	Branch    string       `json:"branch,omitempty"`
+	Commit    string       `json:"commit,omitempty"`
+	Parents   []string     `json:"parents,omitempty"`
+	Subject   string       `json:"subject,omitempty"`
+	From      string       `json:"from,omitempty"`
+	To        string       `json:"to,omitempty"`
+	Files     []fileChange `json:"files,omitempty"`
+	Untracked []string     `json:"untracked,omitempty"`
+	Trailer   bool         `json:"trailer,omitempty"`
+}
+
+// New creates a Tracker and starts polling. Call Close to stop it.
+func New(config Config) *Tracker {
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+	t := &Tracker{
+		config: config,
+		seen:   make(map[string]bool),
+		active: make(map[string]*session),
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go t.poll()
+	return t
+}
+
+// Observe looks at a pipeline message and starts tracking its session the
+// first time it is seen with a workspace inside a git repository. A new
+// session in a repository ends the one that was active there.
+//
+// Observe never runs git itself: the session is handed to the polling
+// goroutine, so the pipeline isn't held up by a slow repository.
+func (t *Tracker) Observe(msg source.Message) {
+	if msg.SessionID == "" || msg.Workspace == "" || msg.Direction == "git" {
+		return
+	}
+	t.mu.Lock()
+	if t.closed || t.seen[msg.SessionID] {
+		t.mu.Unlock()
+		return
+	}
+	t.seen[msg.SessionID] = true
+	t.pending = append(t.pending, msg)
+	t.mu.Unlock()
+
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// track starts tracking the session of msg if its workspace is inside a git
+// repository.
+func (t *Tracker) track(msg source.Message) {
+	root, err := git.Run(msg.Workspace, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return // not a repository (or no git): nothing to link
+	}
+	s := &session{
+		id:         msg.SessionID,
+		sourceName: msg.SourceName,
+		root:       root,
+		head:       revParse(root, "HEAD"),
+	}
+
+	t.mu.Lock()
+	previous := t.active[root]
+	t.active[root] = s
+	t.mu.Unlock()
+
+	if previous != nil {
+		t.end(previous)
+	}
+	t.start(s)
+}
+
+// Close stops polling and ends every active session, including those
+// observed but not yet started.
+func (t *Tracker) Close() {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return
+	}
+	t.closed = true
+	t.mu.Unlock()
+
+	close(t.stop)
+	<-t.done
+
+	t.mu.Lock()
+	sessions := make([]*session, 0, len(t.active))
+	for _, s := range t.active {
+		sessions = append(sessions, s)
+	}
+	t.active = nil
+	t.mu.Unlock()
+	for _, s := range sessions {
+		t.end(s)
+	}
+}
+
+// poll starts observed sessions and checks every active session's HEAD
+// until Close.
+func (t *Tracker) poll() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			t.startPending()
+			return
+		case <-t.wake:
+			t.startPending()
+			continue
+		case <-ticker.C:
+		}
+		t.mu.Lock()
+		sessions := make([]*session, 0, len(t.active))
+		for _, s := range t.active {
+			sessions = append(sessions, s)
+		}
+		t.mu.Unlock()
+		for _, s := range sessions {
+			t.check(s)
+		}
+	}
+}
+
+// startPending starts tracking every session Observe queued.
+func (t *Tracker) startPending() {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	for _, msg := range pending {
+		t.track(msg)
+	}
+}
+
+// start records the session's starting point and arms the trailer hook.
+func (t *Tracker) start(s *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	branch, _ := git.Run(s.root, "symbolic-ref", "--quiet", "--short", "HEAD")
+	if t.config.InstallHook {
+		if err := installHook(s.root); err != nil {
+			fmt.Fprintf(os.Stderr, "[recall/gitlink] %v\n", err)
+		} else if err := writeSessionFile(s.root, s.id); err != nil {
+			fmt.Fprintf(os.Stderr, "[recall/gitlink] %v\n", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "[recall/gitlink] session %s linked to %s\n", s.id, s.root)
+	t.emit(s, record{Type: "session_start", Head: s.head, Branch: branch})
+}
+
+// check emits records for HEAD moves since the last check.
+func (t *Tracker) check(s *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.checkLocked(s)
+}
+
+func (t *Tracker) checkLocked(s *session) {
+	head := revParse(s.root, "HEAD")
+	if head == "" || head == s.head {
+		return
+	}
+	from := s.head
+	s.head = head
+
+	rangeArg := head
+	if from != "" {
+		// Only report commits that descend from where we were; anything else
+		// is a reset or checkout, not new work.
+		if _, err := git.Run(s.root, "merge-base", "--is-ancestor", from, head); err != nil {
+			t.emit(s, record{Type: "head_moved", From: from, To: head})
+			return
+		}
+		rangeArg = from + ".." + head
+	}
+	out, err := git.Run(s.root, "rev-list", "--reverse", rangeArg)
+	if err != nil {
+		return
+	}
+	for _, hash := range strings.Fields(out) {
+		t.emit(s, t.commitRecord(s, hash))
+	}
+}
+
+// commitRecord describes one commit.
+func (t *Tracker) commitRecord(s *session, hash string) record {
+	rec := record{Type: "commit", Commit: hash}
+	format := "%P%x00%s%x00%(trailers:key=" + TrailerKey + ",valueonly,separator=%x01)"
+	if out, err := git.Run(s.root, "show", "-s", "--format="+format, hash); err == nil {
+		parts := strings.SplitN(out, "\x00", 3)
+		if len(parts) == 3 {
+			rec.Parents = strings.Fields(parts[0])
+			rec.Subject = parts[1]
+			for _, value := range strings.Split(parts[2], "\x01") {
+				if strings.TrimSpace(value) == s.id {
+					rec.Trailer = true
+				}
+			}
+		}
+	}
+	if out, err := git.Run(s.root, "diff-tree", "--no-commit-id", "--name-status", "-r", "--root", hash); err == nil {
+		rec.Files = parseNameStatus(out)
+	}
+	return rec
+}
+
+// end describes the uncommitted work left behind and disarms the trailer hook.
+func (t *Tracker) end(s *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.checkLocked(s)
+
+	base := s.head
+	if base == "" {
+		base = emptyTree
+	}
+	rec := record{Type: "session_end", Head: s.head}
+	if out, err := git.Run(s.root, "diff", "--name-status", base); err == nil {
+		rec.Files = parseNameStatus(out)
+	}
+	if out, err := git.Run(s.root, "diff", "--numstat", base); err == nil {
+		addNumstat(rec.Files, out)
+	}
+	if out, err := git.Run(s.root, "ls-files", "--others", "--exclude-standard"); err == nil && out != "" {
+		rec.Untracked = strings.Split(out, "\n")
+	}
+	clearSessionFile(s.root, s.id)
+	t.emit(s, rec)
+}
+
+// emit wraps a record in a message for the session.
+func (t *Tracker) emit(s *session, rec record) {
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	t.emitMu.Lock()
+	defer t.emitMu.Unlock()
+	t.config.Emit(source.Message{
+		Raw:        string(raw),
+		Direction:  "git",
+		SessionID:  s.id,
+		SourceName: s.sourceName,
+		CapturedAt: time.Now().UTC(),
+	})
+}
+
+// parseNameStatus parses `git diff --name-status` output. Renames and copies
+// are reported under their new path.
+func parseNameStatus(out string) []fileChange {
+	var files []fileChange
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		files = append(files, fileChange{
+			Status: fields[0][:1],
+			Path:   fields[len(fields)-1],
+		})
+	}
+	return files
+}
+
+// addNumstat fills in line counts from `git diff --numstat` output. Binary
+// files report "-" and are left without counts.
+func addNumstat(files []fileChange, out string) {
+	byPath := make(map[string]*fileChange, len(files))
+	for i := range files {
+		byPath[files[i].Path] = &files[i]
+	}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		f, ok := byPath[fields[2]]
+		if !ok {
+			continue
+		}
+		if added, err := strconv.Atoi(fields[0]); err == nil {
+			f.Added = &added
+		}
+		if deleted, err := strconv.Atoi(fields[1]); err == nil {
+			f.Deleted = &deleted
+		}
+	}
+}
+
+// revParse resolves a revision, returning "" if it doesn't exist (e.g. HEAD
+// in a repository with no commits yet).
+func revParse(root, rev string) string {
+	out, err := git.Run(root, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return ""
+	}
+	return out
+}
+
+// gitPath resolves a path inside the repository's git directory, honouring
+// worktrees and core.hooksPath.
+func gitPath(root, name string) (string, error) {
+	path, err := git.Run(root, "rev-parse", "--git-path", name)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	return path, nil
+}
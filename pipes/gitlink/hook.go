@@ -0,0 +1,83 @@
+package gitlink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hookMarker identifies hooks we installed, so we only ever replace our own.
+const hookMarker = "# installed by recall-proxy: links commits to agent sessions"
+
+// sessionFileName is the file, inside the git directory, that holds the
+// active session ID for the hook to read, followed by the pid of the recall
+// process that wrote it.
+const sessionFileName = "recall-session"
+
+// hookScript appends the trailer when a session is active. It is a no-op
+// otherwise, so it is harmless to leave installed. A session file left
+// behind by a recall process that died is ignored.
+const hookScript = `#!/bin/sh
+` + hookMarker + `
+f=$(git rev-parse --git-path ` + sessionFileName + `) || exit 0
+[ -s "$f" ] || exit 0
+read -r id pid < "$f"
+[ -n "$id" ] || exit 0
+if [ -n "$pid" ] && ! kill -0 "$pid" 2>/dev/null; then exit 0; fi
+git interpret-trailers --in-place --if-exists addIfDifferent \
+	--trailer "` + TrailerKey + `: $id" "$1"
+`
+
+// installHook writes the prepare-commit-msg hook unless the repository
+// already has one that isn't ours.
+func installHook(root string) error {
+	hooks, err := gitPath(root, "hooks")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(hooks, "prepare-commit-msg")
+	existing, err := os.ReadFile(path)
+	switch {
+	case err == nil && !strings.Contains(string(existing), hookMarker):
+		return fmt.Errorf("%s exists; not installing the %s trailer hook", path, TrailerKey)
+	case err == nil && string(existing) == hookScript:
+		return nil
+	case err != nil && !os.IsNotExist(err):
+		return fmt.Errorf("read hook: %w", err)
+	}
+
+	if err := os.MkdirAll(hooks, 0o755); err != nil {
+		return fmt.Errorf("create hooks dir: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(hookScript), 0o755); err != nil {
+		return fmt.Errorf("write hook: %w", err)
+	}
+	return nil
+}
+
+// writeSessionFile arms the hook with the active session ID.
+func writeSessionFile(root, id string) error {
+	path, err := gitPath(root, sessionFileName)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(fmt.Sprintf("%s %d\n", id, os.Getpid())), 0o600); err != nil {
+		return fmt.Errorf("write session file: %w", err)
+	}
+	return nil
+}
+
+// clearSessionFile disarms the hook, unless another process has since armed
+// it for a different session.
+func clearSessionFile(root, id string) {
+	path, err := gitPath(root, sessionFileName)
+	if err != nil {
+		return
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 0 && fields[0] == id {
+			os.Remove(path)
+		}
+	}
+}
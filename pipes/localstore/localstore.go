@@ -0,0 +1,380 @@
+// Package localstore keeps scrubbed trajectories on the local disk instead
+// of sending them to a server, so a developer can keep a private history of
+// their agent sessions and upload chosen ones later.
+//
+// Every session is one JSONL file under the store directory:
+//
+//	<dir>/<source>/<session>.jsonl
+//
+// holding one transmitter.Payload per line, stamped exactly as the send
+// stage would have sent it, so a stored session can be replayed to a server
+// unchanged (see Client.Deliver). Messages captured before their session ID
+// was known go to "_nosession.jsonl".
+//
+// A session file that grows past Config.MaxFileBytes is rotated: it is
+// renamed <session>.jsonl.1 (then .2, ...) and a new one is started. When
+// the store as a whole exceeds Config.MaxBytes, the least recently written
+// files are deleted until it fits again.
+package localstore
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shshwtsuthar/recall/pipes/transmitter"
+)
+
+// DefaultMaxFileBytes is the size at which a session file is rotated.
+const DefaultMaxFileBytes = 16 << 20
+
+// DefaultMaxBytes bounds the whole store.
+const DefaultMaxBytes = 1 << 30
+
+// ext is the extension of a session file; rotated parts add ".N".
+const ext = ".jsonl"
+
+// noSession names the file of messages without a session ID.
+const noSession = "_nosession"
+
+// maxOpenFiles bounds the session files kept open for appending.
+const maxOpenFiles = 32
+
+// Config configures a Store.
+type Config struct {
+	Dir          string // store directory, created if needed
+	MaxFileBytes int64  // rotation size; 0 means DefaultMaxFileBytes
+	MaxBytes     int64  // store size cap; 0 means DefaultMaxBytes
+}
+
+// Store appends payloads to session files. Its methods are not safe for
+// concurrent use; the pipeline calls them from one goroutine.
+type Store struct {
+	config Config
+	files  map[string]*sessionFile // open files by path
+	size   int64                   // bytes in the store, as of the last prune
+}
+
+// sessionFile is a session file open for appending.
+type sessionFile struct {
+	f    *os.File
+	size int64
+}
+
+// Open returns a Store writing under config.Dir, pruning it first if it is
+// over its cap.
+func Open(config Config) (*Store, error) {
+	if config.MaxFileBytes <= 0 {
+		config.MaxFileBytes = DefaultMaxFileBytes
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = DefaultMaxBytes
+	}
+	if err := os.MkdirAll(config.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create local store: %w", err)
+	}
+	s := &Store{config: config, files: make(map[string]*sessionFile)}
+	if err := s.prune(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Write appends payload to its session's file.
+func (s *Store) Write(payload transmitter.Payload) error {
+	line, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal payload: %w", err)
+	}
+	line = append(line, '\n')
+
+	path := filepath.Join(s.config.Dir, fileName(payload.SourceName), fileName(payload.SessionID)+ext)
+	sf, err := s.open(path)
+	if err != nil {
+		return err
+	}
+	if sf.size > 0 && sf.size+int64(len(line)) > s.config.MaxFileBytes {
+		if sf, err = s.rotate(path); err != nil {
+			return err
+		}
+	}
+	n, err := sf.f.Write(line)
+	sf.size += int64(n)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write local store: %w", err)
+	}
+	if s.size > s.config.MaxBytes {
+		return s.prune()
+	}
+	return nil
+}
+
+// Close closes the open session files.
+func (s *Store) Close() error {
+	var errs []error
+	for path, sf := range s.files {
+		errs = append(errs, sf.f.Close())
+		delete(s.files, path)
+	}
+	return errors.Join(errs...)
+}
+
+// open returns the open file at path, opening it for appending if needed.
+func (s *Store) open(path string) (*sessionFile, error) {
+	if sf, ok := s.files[path]; ok {
+		return sf, nil
+	}
+	if len(s.files) >= maxOpenFiles {
+		s.Close()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create local store: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open session file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open session file: %w", err)
+	}
+	sf := &sessionFile{f: f, size: info.Size()}
+	s.files[path] = sf
+	return sf, nil
+}
+
+// rotate moves the session file at path to its next numbered part and opens
+// a new one.
+func (s *Store) rotate(path string) (*sessionFile, error) {
+	s.files[path].f.Close()
+	delete(s.files, path)
+	parts, _ := filepath.Glob(path + ".*")
+	next := 1
+	for _, p := range parts {
+		if n, err := strconv.Atoi(strings.TrimPrefix(p, path+".")); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	if err := os.Rename(path, path+"."+strconv.Itoa(next)); err != nil {
+		return nil, fmt.Errorf("rotate session file: %w", err)
+	}
+	return s.open(path)
+}
+
+// prune deletes the least recently written files until the store fits in
+// MaxBytes, and records its size.
+func (s *Store) prune() error {
+	type file struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var (
+		files []file
+		total int64
+	)
+	err := filepath.WalkDir(s.config.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isSessionFile(d.Name()) {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // deleted meanwhile
+		}
+		files = append(files, file{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("scan local store: %w", err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if total <= s.config.MaxBytes {
+			break
+		}
+		if sf, ok := s.files[f.path]; ok {
+			sf.f.Close()
+			delete(s.files, f.path)
+		}
+		if err := os.Remove(f.path); err != nil {
+			return fmt.Errorf("prune local store: %w", err)
+		}
+		total -= f.size
+		fmt.Fprintf(os.Stderr, "[recall/local] store over %d bytes, deleted %s\n", s.config.MaxBytes, f.path)
+	}
+	s.size = total
+	return nil
+}
+
+// Session describes one stored session.
+type Session struct {
+	Key        string   // "<source>/<session>", as used by Find
+	SourceName string   // from the first stored message
+	SessionID  string   // likewise
+	Files      []string // oldest part first, the current file last
+	Messages   int
+	Bytes      int64
+	LastWrite  time.Time
+}
+
+// List returns the sessions stored under dir, most recently written first.
+func List(dir string) ([]Session, error) {
+	byKey := make(map[string]*Session)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == dir {
+			return fs.SkipAll
+		}
+		if err != nil || d.IsDir() || !isSessionFile(d.Name()) {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		name, _, _ := strings.Cut(d.Name(), ext)
+		key := filepath.Base(filepath.Dir(path)) + "/" + name
+		sess := byKey[key]
+		if sess == nil {
+			sess = &Session{Key: key}
+			byKey[key] = sess
+		}
+		sess.Files = append(sess.Files, path)
+		sess.Bytes += info.Size()
+		if info.ModTime().After(sess.LastWrite) {
+			sess.LastWrite = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan local store: %w", err)
+	}
+
+	sessions := make([]Session, 0, len(byKey))
+	for _, sess := range byKey {
+		// Oldest part first; the current file (part 0) is the newest.
+		sort.Slice(sess.Files, func(i, j int) bool {
+			pi, _ := part(sess.Files[i])
+			pj, _ := part(sess.Files[j])
+			return pi != 0 && (pj == 0 || pi < pj)
+		})
+		if err := readFiles(sess.Files, func(p transmitter.Payload) error {
+			if sess.Messages == 0 {
+				sess.SourceName, sess.SessionID = p.SourceName, p.SessionID
+			}
+			sess.Messages++
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *sess)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastWrite.After(sessions[j].LastWrite) })
+	return sessions, nil
+}
+
+// Find returns the stored sessions matching selector: a key as reported
+// by List, or a session ID.
+func Find(dir, selector string) ([]Session, error) {
+	sessions, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	var found []Session
+	for _, sess := range sessions {
+		if sess.Key == selector || (sess.SessionID == selector && selector != "") {
+			found = append(found, sess)
+		}
+	}
+	return found, nil
+}
+
+// Read calls fn with every stored payload of sess, in the order written,
+// until fn returns an error, which Read returns.
+func Read(sess Session, fn func(transmitter.Payload) error) error {
+	return readFiles(sess.Files, fn)
+}
+
+func readFiles(paths []string, fn func(transmitter.Payload) error) error {
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("read session file: %w", err)
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+		for scanner.Scan() {
+			var payload transmitter.Payload
+			if json.Unmarshal(scanner.Bytes(), &payload) != nil {
+				continue
+			}
+			if err := fn(payload); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// isSessionFile reports whether name is a session file or a rotated part.
+func isSessionFile(name string) bool {
+	_, ok := part(name)
+	return ok
+}
+
+// part returns the rotation number of a session file path: 0 for the
+// current file, and false if path is no session file.
+func part(path string) (int, bool) {
+	if strings.HasSuffix(path, ext) {
+		return 0, true
+	}
+	i := strings.LastIndex(path, ext+".")
+	if i < 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(path[i+len(ext)+1:])
+	return n, err == nil && n > 0
+}
+
+// fileName turns a source name or session ID into a safe file name. Names
+// that had to be changed get a hash suffix so they stay distinct.
+func fileName(id string) string {
+	if id == "" {
+		return noSession
+	}
+	safe := []byte(id)
+	changed := false
+	for i, c := range safe {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			safe[i] = '_'
+			changed = true
+		}
+	}
+	if len(safe) > 64 {
+		safe = safe[:64]
+		changed = true
+	}
+	if !changed {
+		return id
+	}
+	sum := sha256.Sum256([]byte(id))
+	return string(safe) + "-" + hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,548 @@
+// Package optout turns capture off for sensitive workspaces and files.
+//
+// A session is suppressed when its workspace:
+//
+//   - or any parent directory contains a .recallignore file with no
+//     patterns in it (empty, or only comments);
+//   - matches one of Config.Paths, glob patterns matched against the
+//     workspace and each of its parents ("~/clients/*");
+//   - has a git remote ("origin", or else the first) matching one of
+//     Config.Remotes, glob patterns matched against the normalized remote
+//     URL ("github.com/acme-corp/*", see git.NormalizeRemote).
+//
+// Every message of a suppressed session is dropped, and replaced by a single
+// "control" record saying the session was suppressed and why — without the
+// workspace path or remote. The ACP session/new request is recognized by
+// its cwd parameter, before the agent has even assigned a session ID. With
+// several sessions open, a message belongs to the session its params name,
+// and a response to the session its request named.
+//
+// Workspaces are looked at in the background, so the marker files and git
+// never hold up the pipeline. Until a workspace's first decision is in,
+// OptOut holds its messages, and every message after them, back; later
+// decisions are refreshed while the previous one stays in use.
+//
+// A .recallignore that does contain patterns, and Config.Files, suppress
+// individual files instead: the agent's fs/read_text_file requests for them
+// and the responses with their contents are dropped. Patterns use
+// filepath.Match syntax. One without a slash matches a file's base name;
+// one with a slash matches its path relative to the .recallignore
+// directory, or to the workspace for Config.Files; a trailing slash matches
+// everything under a directory.
+package optout
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shshwtsuthar/recall/internal/git"
+	"github.com/shshwtsuthar/recall/source"
+)
+
+// IgnoreFile is the name of the marker file.
+const IgnoreFile = ".recallignore"
+
+// Reasons a session is suppressed, as reported in the control record.
+const (
+	ReasonIgnoreFile = "recallignore"
+	ReasonPath       = "path"
+	ReasonRemote     = "remote"
+)
+
+// decisionTTL is how long a workspace's decision is cached before the
+// marker files and remote are looked at again.
+const decisionTTL = time.Minute
+
+// maxTracked bounds the tables of requests awaiting a response.
+const maxTracked = 10000
+
+// Config holds the user's denylists. A .recallignore works without any.
+type Config struct {
+	Paths   []string // workspace path globs
+	Remotes []string // normalized git remote globs
+	Files   []string // file globs whose reads are suppressed in every workspace
+}
+
+// OptOut decides which messages to drop. Except for Attach and Close, its
+// methods are not safe for concurrent use; the pipeline calls them from one
+// goroutine.
+type OptOut struct {
+	config Config
+
+	decisions  map[string]decision   // by workspace
+	evaluating map[string]bool       // workspaces being looked at
+	held       []message             // awaiting a first decision, in order
+	suppressed map[string]string     // session ID → reason
+	workspaces map[string]string     // session ID → workspace
+	requests   map[requestKey]string // requests naming a session → that session
+	reads      map[readKey]bool      // suppressed fs reads awaiting a response
+
+	lookups sync.WaitGroup
+
+	mu       sync.Mutex
+	results  map[string]decision // finished lookups, not yet in decisions
+	wake     func()
+	stopping bool // no new lookups
+	closed   bool // drop what is still held
+}
+
+// message is a message with its JSON-RPC envelope parsed.
+type message struct {
+	source.Message
+	env   rpcEnvelope
+	isRPC bool
+}
+
+// decision is the verdict for one workspace.
+type decision struct {
+	reason  string   // "" if not suppressed
+	root    string   // directory of the nearest .recallignore with patterns
+	files   []string // file patterns from that .recallignore
+	checked time.Time
+}
+
+// requestKey identifies a JSON-RPC request on one source's connection.
+type requestKey struct {
+	source    string
+	direction string // direction of the request, not the response
+	id        string
+}
+
+// readKey identifies an fs read within a session. Editor and agent number
+// their requests independently, so the direction tells their ids apart.
+type readKey struct {
+	sessionID string
+	direction string // direction of the request, not the response
+	id        string
+}
+
+// New validates config's patterns and returns an OptOut.
+func New(config Config) (*OptOut, error) {
+	paths := make([]string, len(config.Paths))
+	for i, p := range config.Paths {
+		paths[i] = expandHome(p)
+	}
+	config.Paths = paths
+	for _, list := range [][]string{config.Paths, config.Remotes, config.Files} {
+		for _, p := range list {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+			}
+		}
+	}
+	return &OptOut{
+		config:     config,
+		decisions:  make(map[string]decision),
+		evaluating: make(map[string]bool),
+		suppressed: make(map[string]string),
+		workspaces: make(map[string]string),
+		requests:   make(map[requestKey]string),
+		reads:      make(map[readKey]bool),
+		results:    make(map[string]decision),
+	}, nil
+}
+
+// Attach sets the function called when a lookup finishes and held messages
+// may be released (the stage's Wake).
+func (o *OptOut) Attach(wake func()) {
+	o.mu.Lock()
+	o.wake = wake
+	o.mu.Unlock()
+}
+
+// rpcEnvelope is the part of a JSON-RPC message the decisions look at.
+type rpcEnvelope struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params struct {
+		SessionID string `json:"sessionId"`
+		Cwd       string `json:"cwd"`
+		Path      string `json:"path"`
+	} `json:"params"`
+}
+
+// Process returns what to pass on for msg: msg itself, nothing, or the
+// control record replacing the first message of a suppressed session.
+// While a workspace is looked at for the first time, it holds msg back
+// instead and returns nothing; Release returns it later.
+func (o *OptOut) Process(msg source.Message) []source.Message {
+	m := message{Message: msg}
+	m.isRPC = json.Unmarshal([]byte(msg.Raw), &m.env) == nil
+
+	o.collect()
+	if undecided := o.undecided(m); undecided || len(o.held) > 0 {
+		o.held = append(o.held, m)
+		return nil
+	}
+	return o.process(m)
+}
+
+// Held returns the number of messages held back.
+func (o *OptOut) Held() int {
+	return len(o.held)
+}
+
+// Release returns what Process would have returned for each held message
+// whose workspace has been decided, in order, up to the first one still
+// waiting. After Close, the messages still waiting are dropped.
+func (o *OptOut) Release() [][]source.Message {
+	o.collect()
+	o.mu.Lock()
+	closed := o.closed
+	o.mu.Unlock()
+
+	var outs [][]source.Message
+	dropped := 0
+	for len(o.held) > 0 {
+		m := o.held[0]
+		if !o.undecided(m) {
+			outs = append(outs, o.process(m))
+		} else if closed {
+			outs = append(outs, nil)
+			dropped++
+		} else {
+			break
+		}
+		o.held = o.held[1:]
+	}
+	if dropped > 0 {
+		fmt.Fprintf(os.Stderr, "[recall/optout] dropped %d messages of workspaces not looked at before exit\n", dropped)
+	}
+	return outs
+}
+
+// Close waits, until ctx is done, for the lookups in flight, then releases
+// what they decided and drops the rest.
+func (o *OptOut) Close(ctx context.Context) {
+	o.mu.Lock()
+	o.stopping = true
+	o.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		o.lookups.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	o.mu.Lock()
+	o.closed = true
+	wake := o.wake
+	o.mu.Unlock()
+	if wake != nil {
+		wake()
+	}
+}
+
+// process decides on a message whose workspaces have been looked at.
+func (o *OptOut) process(m message) []source.Message {
+	msg, env, isRPC := m.Message, m.env, m.isRPC
+
+	// A session/new or session/load request names its workspace before
+	// the session is known to anyone else.
+	if opensSession(m) {
+		if d := o.decide(env.Params.Cwd); d.reason != "" {
+			sessionID := env.Params.SessionID // set for session/load only
+			if sessionID == "" {
+				return nil
+			}
+			return o.suppress(msg, sessionID, d.reason)
+		}
+	}
+
+	sessionID, workspace := o.session(m)
+	if _, ok := o.suppressed[sessionID]; ok && sessionID != "" {
+		return nil
+	}
+	d := o.decide(workspace)
+	if d.reason != "" && sessionID != "" {
+		return o.suppress(msg, sessionID, d.reason)
+	}
+	if d.reason != "" {
+		return nil
+	}
+
+	if isRPC && o.suppressRead(msg, env, sessionID, workspace, d) {
+		return nil
+	}
+	return []source.Message{msg}
+}
+
+// session returns the session m belongs to and its workspace. The source
+// labels messages with the session it saw opened last, so with several
+// sessions open the session a request names (params.sessionId), or the one
+// its request named for a response, takes precedence.
+func (o *OptOut) session(m message) (sessionID, workspace string) {
+	if opensSession(m) {
+		return m.env.Params.SessionID, m.env.Params.Cwd // "" until session/new is answered
+	}
+	if m.SessionID != "" && m.Workspace != "" {
+		o.workspaces[m.SessionID] = m.Workspace
+	}
+	sessionID = m.SessionID
+	if m.isRPC && m.env.ID != nil {
+		if m.env.Method != "" && m.env.Params.SessionID != "" {
+			if len(o.requests) >= maxTracked {
+				clear(o.requests)
+			}
+			o.requests[requestKey{m.SourceName, m.Direction, string(m.env.ID)}] = m.env.Params.SessionID
+		} else if m.env.Method == "" {
+			key := requestKey{m.SourceName, source.Opposite(m.Direction), string(m.env.ID)}
+			if id, ok := o.requests[key]; ok {
+				sessionID = id
+				delete(o.requests, key)
+			}
+		}
+	}
+	if m.isRPC && m.env.Params.SessionID != "" {
+		sessionID = m.env.Params.SessionID
+	}
+	if sessionID == m.SessionID {
+		return sessionID, m.Workspace
+	}
+	return sessionID, o.workspaces[sessionID]
+}
+
+// opensSession reports whether m is a session/new or session/load request.
+func opensSession(m message) bool {
+	return m.isRPC && m.Direction == "upstream" && (m.env.Method == "session/new" || m.env.Method == "session/load")
+}
+
+// undecided reports whether a workspace m belongs to has no decision yet,
+// and starts looking at it.
+func (o *OptOut) undecided(m message) bool {
+	workspaces := []string{m.Workspace}
+	if opensSession(m) {
+		workspaces = append(workspaces, m.env.Params.Cwd)
+	}
+	undecided := false
+	for _, ws := range workspaces {
+		if _, ok := o.decisions[ws]; ws != "" && !ok {
+			o.lookup(ws)
+			undecided = true
+		}
+	}
+	return undecided
+}
+
+// suppress marks sessionID as suppressed and returns its control record.
+func (o *OptOut) suppress(msg source.Message, sessionID, reason string) []source.Message {
+	o.suppressed[sessionID] = reason
+	fmt.Fprintf(os.Stderr, "[recall/optout] session %s suppressed (%s)\n", sessionID, reason)
+	raw, _ := json.Marshal(map[string]string{"type": "session_suppressed", "reason": reason})
+	return []source.Message{{
+		Raw:        string(raw),
+		Direction:  "control",
+		SessionID:  sessionID,
+		SourceName: msg.SourceName,
+		CapturedAt: msg.CapturedAt,
+	}}
+}
+
+// suppressRead reports whether msg, of session sessionID in workspace, is a
+// read of an ignored file, or the response to one.
+func (o *OptOut) suppressRead(msg source.Message, env rpcEnvelope, sessionID, workspace string, d decision) bool {
+	if env.ID == nil {
+		return false
+	}
+	if env.Method == "" {
+		// A response travels the opposite way to its request.
+		key := readKey{sessionID, source.Opposite(msg.Direction), string(env.ID)}
+		if o.reads[key] {
+			delete(o.reads, key)
+			return true
+		}
+		return false
+	}
+	if env.Method != "fs/read_text_file" || env.Params.Path == "" {
+		return false
+	}
+	if !o.ignoredFile(env.Params.Path, workspace, d) {
+		return false
+	}
+	if len(o.reads) >= maxTracked {
+		clear(o.reads)
+	}
+	o.reads[readKey{sessionID, msg.Direction, string(env.ID)}] = true
+	return true
+}
+
+// ignoredFile reports whether path matches the workspace's .recallignore
+// patterns or Config.Files.
+func (o *OptOut) ignoredFile(path, workspace string, d decision) bool {
+	if d.root != "" && matchAny(d.files, path, d.root) {
+		return true
+	}
+	base := workspace
+	if base == "" {
+		base = filepath.Dir(path)
+	}
+	return matchAny(o.config.Files, path, base)
+}
+
+// decide returns the cached decision for a workspace, and refreshes it in
+// the background once it is older than decisionTTL.
+func (o *OptOut) decide(workspace string) decision {
+	if workspace == "" {
+		return decision{}
+	}
+	d := o.decisions[workspace]
+	if time.Since(d.checked) >= decisionTTL {
+		o.lookup(workspace)
+	}
+	return d
+}
+
+// lookup evaluates workspace in the background, unless that is already
+// underway.
+func (o *OptOut) lookup(workspace string) {
+	if o.evaluating[workspace] {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stopping {
+		return
+	}
+	o.evaluating[workspace] = true
+	o.lookups.Add(1)
+	go func() {
+		defer o.lookups.Done()
+		d := o.evaluate(workspace)
+		d.checked = time.Now()
+
+		o.mu.Lock()
+		o.results[workspace] = d
+		wake := o.wake
+		o.mu.Unlock()
+		if wake != nil {
+			wake()
+		}
+	}()
+}
+
+// collect moves finished lookups into the decisions.
+func (o *OptOut) collect() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for workspace, d := range o.results {
+		o.decisions[workspace] = d
+		delete(o.evaluating, workspace)
+	}
+	clear(o.results)
+}
+
+func (o *OptOut) evaluate(workspace string) decision {
+	var d decision
+	for dir := filepath.Clean(workspace); ; dir = filepath.Dir(dir) {
+		if patterns, ok := readIgnoreFile(filepath.Join(dir, IgnoreFile)); ok {
+			if len(patterns) == 0 {
+				return decision{reason: ReasonIgnoreFile}
+			}
+			if d.root == "" {
+				d.root, d.files = dir, patterns
+			}
+		}
+		for _, p := range o.config.Paths {
+			if ok, _ := filepath.Match(p, dir); ok {
+				return decision{reason: ReasonPath}
+			}
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+	if len(o.config.Remotes) > 0 {
+		if remote := git.RemoteURL(workspace); remote != "" {
+			normalized := git.NormalizeRemote(remote)
+			for _, p := range o.config.Remotes {
+				if ok, _ := filepath.Match(p, normalized); ok {
+					return decision{reason: ReasonRemote}
+				}
+			}
+		}
+	}
+	return d
+}
+
+// readIgnoreFile returns the patterns in a .recallignore, and whether the
+// file exists. Blank lines and lines starting with # are skipped.
+func readIgnoreFile(path string) ([]string, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := filepath.Match(strings.TrimSuffix(line, "/"), ""); err != nil {
+			fmt.Fprintf(os.Stderr, "[recall/optout] %s: skipping invalid pattern %q\n", path, line)
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, true
+}
+
+// matchAny reports whether path matches one of the file patterns, with
+// patterns containing a slash taken relative to base.
+func matchAny(patterns []string, path, base string) bool {
+	rel, err := filepath.Rel(base, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = ""
+	}
+	rel = filepath.ToSlash(rel)
+	name := filepath.Base(path)
+	for _, p := range patterns {
+		switch {
+		case strings.HasSuffix(p, "/"):
+			dir := strings.TrimPrefix(strings.TrimSuffix(p, "/"), "/")
+			if !strings.Contains(dir, "/") {
+				// A bare directory name matches at any depth.
+				for _, part := range strings.Split(filepath.ToSlash(filepath.Dir(path)), "/") {
+					if ok, _ := filepath.Match(dir, part); ok {
+						return true
+					}
+				}
+			} else if rel != "" && (rel == dir || strings.HasPrefix(rel, dir+"/")) {
+				return true
+			}
+		case strings.Contains(p, "/"):
+			if ok, _ := filepath.Match(strings.TrimPrefix(p, "/"), rel); ok && rel != "" {
+				return true
+			}
+		default:
+			if ok, _ := filepath.Match(p, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expandHome replaces a leading "~/" with the user's home directory.
+func expandHome(p string) string {
+	if !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, p[2:])
+}
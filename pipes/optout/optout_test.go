@@ -0,0 +1,84 @@
+package optout
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shshwtsuthar/recall/source"
+)
+
+// run passes msgs through o, waiting for each one's workspace lookup, and
+// returns what came out.
+func run(t *testing.T, o *OptOut, msgs []source.Message) []source.Message {
+	t.Helper()
+	woke := make(chan struct{}, len(msgs)*2)
+	o.Attach(func() { woke <- struct{}{} })
+	var out []source.Message
+	for _, msg := range msgs {
+		out = append(out, o.Process(msg)...)
+		for o.Held() > 0 {
+			select {
+			case <-woke:
+			case <-time.After(5 * time.Second):
+				t.Fatal("workspace lookup did not finish")
+			}
+			for _, outs := range o.Release() {
+				out = append(out, outs...)
+			}
+		}
+	}
+	return out
+}
+
+func TestConcurrentSessions(t *testing.T) {
+	ignored, open := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(ignored, IgnoreFile), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	o, err := New(Config{Files: []string{"*.pem"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Labelled as the ACP source does: with the session opened last.
+	msg := func(direction, sessionID, workspace, raw string) source.Message {
+		return source.Message{Raw: raw, Direction: direction, SessionID: sessionID, Workspace: workspace, SourceName: "acp"}
+	}
+	key := filepath.Join(open, "key.pem")
+	in := []source.Message{
+		msg("upstream", "", "", `{"id":1,"method":"session/new","params":{"cwd":"`+ignored+`"}}`),
+		msg("downstream", "a", ignored, `{"id":1,"result":{"sessionId":"a"}}`),
+		msg("upstream", "a", ignored, `{"id":2,"method":"session/new","params":{"cwd":"`+open+`"}}`),
+		msg("downstream", "b", open, `{"id":2,"result":{"sessionId":"b"}}`),
+		msg("upstream", "b", open, `{"id":3,"method":"session/prompt","params":{"sessionId":"a"}}`),
+		msg("downstream", "b", open, `{"method":"session/update","params":{"sessionId":"a"}}`),
+		msg("downstream", "b", open, `{"id":3,"result":{"stopReason":"end_turn"}}`),
+		msg("upstream", "b", open, `{"id":4,"method":"session/prompt","params":{"sessionId":"b"}}`),
+		// The agent's read shares its id with the editor's prompt.
+		msg("downstream", "b", open, `{"id":4,"method":"fs/read_text_file","params":{"sessionId":"b","path":"`+key+`"}}`),
+		msg("upstream", "b", open, `{"id":4,"result":{"content":"PRIVATE KEY"}}`),
+		msg("downstream", "b", open, `{"id":4,"result":{"stopReason":"end_turn"}}`),
+	}
+	want := []string{
+		`{"reason":"recallignore","type":"session_suppressed"}`,
+		in[2].Raw, in[3].Raw, in[7].Raw, in[10].Raw,
+	}
+
+	out := run(t, o, in)
+	if len(out) != len(want) {
+		for _, m := range out {
+			t.Log(m.Raw)
+		}
+		t.Fatalf("got %d messages, want %d", len(out), len(want))
+	}
+	for i, m := range out {
+		if m.Raw != want[i] {
+			t.Errorf("message %d = %s, want %s", i, m.Raw, want[i])
+		}
+	}
+	if out[0].SessionID != "a" {
+		t.Errorf("suppressed session %q, want a", out[0].SessionID)
+	}
+}
@@ -0,0 +1,188 @@
+// Package pause stops and restarts capture at runtime, for every session or
+// for individual ones, while traffic keeps flowing between editor and agent.
+//
+// A Switch holds the state; it is flipped from outside the pipeline (the
+// control socket, signals) and consulted by the "pause" stage for every
+// message. Messages of a paused session are dropped. The trajectory shows
+// the gap explicitly: a "control" record
+//
+//	{"type":"capture_paused","scope":"all"}
+//
+// is inserted when a session's capture stops, and one of type
+// "capture_resumed" when it starts again. Scope is "all" or "session",
+// depending on what was paused. Sessions already seen get their marker as
+// soon as the state changes; a session first seen while paused gets it
+// with its first message.
+package pause
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shshwtsuthar/recall/source"
+)
+
+// Status is a snapshot of a Switch.
+type Status struct {
+	// All is whether capture is paused for sessions without their own setting.
+	All bool `json:"all"`
+
+	// Paused and Resumed list the sessions paused or resumed individually,
+	// overriding All.
+	Paused  []string `json:"paused,omitempty"`
+	Resumed []string `json:"resumed,omitempty"`
+}
+
+// Switch is the pause state. It is safe for concurrent use.
+type Switch struct {
+	mu       sync.Mutex
+	all      bool
+	sessions map[string]bool // per-session override: true = paused
+
+	emit    func(source.Message) // set by the stage
+	marked  map[string]bool      // last marker state per session seen
+	sources map[string]string    // session → source name, for markers
+}
+
+// NewSwitch returns a Switch with capture running.
+func NewSwitch() *Switch {
+	return &Switch{
+		sessions: make(map[string]bool),
+		marked:   make(map[string]bool),
+		sources:  make(map[string]string),
+	}
+}
+
+// Pause stops capture for sessionID, or for every session if it is "".
+func (s *Switch) Pause(sessionID string) { s.set(sessionID, true) }
+
+// Resume restarts capture for sessionID, or for every session if it is "".
+func (s *Switch) Resume(sessionID string) { s.set(sessionID, false) }
+
+func (s *Switch) set(sessionID string, paused bool) {
+	s.mu.Lock()
+	if sessionID == "" {
+		s.all = paused
+		clear(s.sessions)
+	} else {
+		s.sessions[sessionID] = paused
+	}
+	markers := s.markChanges()
+	emit := s.emit
+	s.mu.Unlock()
+
+	verb := "resumed"
+	if paused {
+		verb = "paused"
+	}
+	target := "all sessions"
+	if sessionID != "" {
+		target = "session " + sessionID
+	}
+	fmt.Fprintf(os.Stderr, "[recall/pause] capture %s for %s\n", verb, target)
+
+	if emit != nil {
+		for _, m := range markers {
+			emit(m)
+		}
+	}
+}
+
+// Paused reports whether capture is paused for sessionID.
+func (s *Switch) Paused(sessionID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused(sessionID)
+}
+
+// Status returns the current state.
+func (s *Switch) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := Status{All: s.all}
+	for id, paused := range s.sessions {
+		if paused {
+			st.Paused = append(st.Paused, id)
+		} else {
+			st.Resumed = append(st.Resumed, id)
+		}
+	}
+	sort.Strings(st.Paused)
+	sort.Strings(st.Resumed)
+	return st
+}
+
+// Process implements the pipeline stage: it drops msg if its session is
+// paused, preceded by a marker if the session's state changed unnoticed.
+func (s *Switch) Process(msg source.Message) []source.Message {
+	s.mu.Lock()
+	paused := s.paused(msg.SessionID)
+	var out []source.Message
+	if id := msg.SessionID; id != "" {
+		s.sources[id] = msg.SourceName
+		// An unseen session counts as running, so it gets a marker only if
+		// its first message is already paused.
+		if s.marked[id] != paused {
+			out = append(out, s.marker(id, paused))
+		}
+		s.marked[id] = paused
+	}
+	s.mu.Unlock()
+
+	if paused {
+		return out
+	}
+	return append(out, msg)
+}
+
+// Attach sets where markers for state changes are sent (the stage's Emit).
+func (s *Switch) Attach(emit func(source.Message)) {
+	s.mu.Lock()
+	s.emit = emit
+	s.mu.Unlock()
+}
+
+// paused reports whether capture is paused for sessionID. The caller holds s.mu.
+func (s *Switch) paused(sessionID string) bool {
+	if p, ok := s.sessions[sessionID]; ok && sessionID != "" {
+		return p
+	}
+	return s.all
+}
+
+// markChanges returns markers for the seen sessions whose state differs
+// from their last marker, and records the new state. The caller holds s.mu.
+func (s *Switch) markChanges() []source.Message {
+	var markers []source.Message
+	for id, was := range s.marked {
+		if now := s.paused(id); now != was {
+			markers = append(markers, s.marker(id, now))
+			s.marked[id] = now
+		}
+	}
+	return markers
+}
+
+// marker builds the control record for a session. The caller holds s.mu.
+func (s *Switch) marker(sessionID string, paused bool) source.Message {
+	kind := "capture_resumed"
+	if paused {
+		kind = "capture_paused"
+	}
+	scope := "all"
+	if _, ok := s.sessions[sessionID]; ok {
+		scope = "session"
+	}
+	raw, _ := json.Marshal(map[string]string{"type": kind, "scope": scope})
+	return source.Message{
+		Raw:        string(raw),
+		Direction:  "control",
+		SessionID:  sessionID,
+		SourceName: s.sources[sessionID],
+		CapturedAt: time.Now().UTC(),
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package retention
+
+import "os"
+
+// processAlive reports whether a process with the given pid exists. Finding
+// a process fails on these platforms once it has exited.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,133 @@
+package retention
+
+import (
+	"encoding/json"
+	"path/filepath"
+)
+
+// proposal is one file edit an agent proposed.
+//
+// A full write carries the whole new content; a partial edit only carries
+// the replaced fragment (OldText/NewText are then excerpts, not files).
+type proposal struct {
+	Path    string
+	Full    bool
+	OldText *string // nil when the previous content is unknown
+	NewText string
+}
+
+// extractProposals finds the file edits proposed in one message. It
+// understands:
+//
+//   - ACP fs/write_text_file requests (agent → client), full writes
+//   - ACP session/update tool calls with "diff" content, full writes with
+//     the previous content
+//   - Claude Code transcript tool_use entries for Write (full), Edit and
+//     MultiEdit (partial)
+//
+// Relative paths are resolved against workspace.
+func extractProposals(raw, workspace string) []proposal {
+	var env struct {
+		Method  string `json:"method"`
+		Params  json.RawMessage
+		Message *struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(raw), &env); err != nil {
+		return nil
+	}
+
+	var found []proposal
+	switch {
+	case env.Method == "fs/write_text_file":
+		var params struct {
+			Path    string `json:"path"`
+			Content string `json:"content"`
+		}
+		if json.Unmarshal(env.Params, &params) == nil && params.Path != "" {
+			found = append(found, proposal{Path: params.Path, Full: true, NewText: params.Content})
+		}
+
+	case env.Method == "session/update":
+		var params struct {
+			Update struct {
+				Content []struct {
+					Type    string  `json:"type"`
+					Path    string  `json:"path"`
+					OldText *string `json:"oldText"`
+					NewText string  `json:"newText"`
+				} `json:"content"`
+			} `json:"update"`
+		}
+		if json.Unmarshal(env.Params, &params) != nil {
+			return nil
+		}
+		for _, c := range params.Update.Content {
+			if c.Type == "diff" && c.Path != "" {
+				// A null oldText means the file is new.
+				old := c.OldText
+				if old == nil {
+					empty := ""
+					old = &empty
+				}
+				found = append(found, proposal{Path: c.Path, Full: true, OldText: old, NewText: c.NewText})
+			}
+		}
+
+	case env.Message != nil:
+		var blocks []struct {
+			Type  string          `json:"type"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
+		}
+		if json.Unmarshal(env.Message.Content, &blocks) != nil {
+			return nil // content is a plain string: no tool use
+		}
+		for _, b := range blocks {
+			if b.Type == "tool_use" {
+				found = append(found, toolUseProposals(b.Name, b.Input)...)
+			}
+		}
+	}
+
+	for i := range found {
+		if !filepath.IsAbs(found[i].Path) && workspace != "" {
+			found[i].Path = filepath.Join(workspace, found[i].Path)
+		}
+		found[i].Path = filepath.Clean(found[i].Path)
+	}
+	return found
+}
+
+// toolUseProposals converts a Claude Code file-editing tool call.
+func toolUseProposals(name string, input json.RawMessage) []proposal {
+	type edit struct {
+		OldString string `json:"old_string"`
+		NewString string `json:"new_string"`
+	}
+	var in struct {
+		FilePath string `json:"file_path"`
+		Content  string `json:"content"`
+		edit
+		Edits []edit `json:"edits"`
+	}
+	if json.Unmarshal(input, &in) != nil || in.FilePath == "" {
+		return nil
+	}
+
+	switch name {
+	case "Write":
+		return []proposal{{Path: in.FilePath, Full: true, NewText: in.Content}}
+	case "Edit":
+		return []proposal{{Path: in.FilePath, OldText: &in.OldString, NewText: in.NewString}}
+	case "MultiEdit":
+		var found []proposal
+		for _, e := range in.Edits {
+			old := e.OldString
+			found = append(found, proposal{Path: in.FilePath, OldText: &old, NewText: e.NewString})
+		}
+		return found
+	}
+	return nil
+}
@@ -0,0 +1,519 @@
+// Package retention labels sessions with how much of the agent's work
+// survived: for every file the agent proposed to write or edit, it later
+// compares the proposal with what is actually on disk and classifies the
+// file as kept, modified or reverted.
+//
+// An evaluation happens when the first of these occurs:
+//
+//   - Config.Delay has passed since the session's last message ("delay")
+//   - HEAD of the workspace's repository moved after the session went quiet,
+//     i.e. the user committed ("commit")
+//
+// Pending evaluations are persisted, so sessions that end shortly before
+// recall exits are evaluated on the next start. Every recall process keeps
+// its own state file, <pid>.json in Config.StateDir, so concurrent processes
+// never overwrite or evaluate each other's sessions; a process adopts the
+// files of processes that are no longer running. Only hashes of proposed
+// content are stored, never the content itself.
+//
+// The result is one "outcome" message per evaluation:
+//
+//	{"type":"edit_retention","trigger":"delay","kept":2,"modified":1,"reverted":0,
+//	 "proposed_lines":40,"retained_lines":35,"retention":0.875,
+//	 "files":[{"path":"main.go","status":"kept","proposed_lines":12,"retained_lines":12},...]}
+package retention
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shshwtsuthar/recall/internal/git"
+	"github.com/shshwtsuthar/recall/source"
+)
+
+// DefaultDelay is how long after a session goes quiet it is evaluated when
+// Config.Delay is zero.
+const DefaultDelay = time.Hour
+
+// checkInterval is how often pending evaluations are checked.
+const checkInterval = 30 * time.Second
+
+// Statuses of an evaluated file.
+const (
+	Kept     = "kept"     // the proposal is on disk unchanged
+	Modified = "modified" // some of the proposed lines survive
+	Reverted = "reverted" // none survive, or the file is back to its original
+)
+
+// Config holds Tracker configuration.
+type Config struct {
+	// StateDir is where pending evaluations are persisted, one file per
+	// recall process. It is created if needed.
+	StateDir string
+
+	// Delay is how long after a session's last message it is evaluated,
+	// unless a commit comes first. Defaults to DefaultDelay.
+	Delay time.Duration
+
+	// Emit receives every outcome record. Calls are serialized.
+	Emit func(source.Message)
+}
+
+// Tracker records proposed edits and evaluates them later.
+type Tracker struct {
+	config Config
+	path   string // this process's state file
+
+	mu      sync.Mutex
+	pending map[string]*pending // session ID → edits awaiting evaluation
+	dirty   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// pending is one session's edits awaiting evaluation.
+type pending struct {
+	SourceName   string                `json:"source_name"`
+	Workspace    string                `json:"workspace,omitempty"`
+	LastActivity time.Time             `json:"last_activity"`
+	Head         string                `json:"head,omitempty"`
+	HeadAt       time.Time             `json:"head_at"`
+	Files        map[string]*fileState `json:"files"`
+}
+
+// fileState is what is known about one proposed file, as hashes.
+type fileState struct {
+	// Original is the hash of the file before the agent touched it, or ""
+	// if unknown.
+	Original string `json:"original,omitempty"`
+
+	// Proposed is the hash of the last full content the agent proposed, or
+	// "" if only partial edits are known since.
+	Proposed string `json:"proposed,omitempty"`
+
+	// Added holds line hashes the agent introduced (blank lines excluded).
+	Added []string `json:"added"`
+}
+
+// fileOutcome is one file in an outcome record.
+type fileOutcome struct {
+	Path          string `json:"path"`
+	Status        string `json:"status"`
+	ProposedLines int    `json:"proposed_lines"`
+	RetainedLines int    `json:"retained_lines"`
+}
+
+// outcome is the JSON body of an outcome message.
+type outcome struct {
+	Type          string        `json:"type"`
+	Trigger       string        `json:"trigger"`
+	Kept          int           `json:"kept"`
+	Modified      int           `json:"modified"`
+	Reverted      int           `json:"reverted"`
+	ProposedLines int           `json:"proposed_lines"`
+	RetainedLines int           `json:"retained_lines"`
+	Retention     float64       `json:"retention"`
+	Files         []fileOutcome `json:"files"`
+}
+
+// New adopts the pending evaluations of recall processes that are no longer
+// running and starts checking them. Evaluations that fell due while recall
+// was not running are emitted on the first check. Call Close to stop.
+func New(config Config) (*Tracker, error) {
+	if config.Delay <= 0 {
+		config.Delay = DefaultDelay
+	}
+	if err := os.MkdirAll(config.StateDir, 0o700); err != nil {
+		return nil, fmt.Errorf("create retention state dir: %w", err)
+	}
+	pid := os.Getpid()
+	t := &Tracker{
+		config:  config,
+		path:    filepath.Join(config.StateDir, strconv.Itoa(pid)+".json"),
+		pending: make(map[string]*pending),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := t.adopt(pid); err != nil {
+		return nil, err
+	}
+	if len(t.pending) > 0 {
+		fmt.Fprintf(os.Stderr, "[recall/retention] %d session(s) awaiting evaluation\n", len(t.pending))
+	}
+
+	go t.run()
+	return t, nil
+}
+
+// adopt takes over the state files whose process is gone. Each is first
+// renamed to <pid>.adopt-<name>, so of several starting processes exactly
+// one adopts it, and only removed once its sessions are saved as ours.
+func (t *Tracker) adopt(pid int) error {
+	paths, err := filepath.Glob(filepath.Join(t.config.StateDir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("list retention state: %w", err)
+	}
+	var adopted []string
+	for _, path := range paths {
+		owner, ok := ownerPID(filepath.Base(path))
+		if !ok || owner != pid && processAlive(owner) {
+			continue
+		}
+		claimed := path
+		if owner != pid {
+			claimed = filepath.Join(t.config.StateDir, strconv.Itoa(pid)+".adopt-"+filepath.Base(path))
+			if err := os.Rename(path, claimed); err != nil {
+				continue // another process adopted it first
+			}
+		}
+		data, err := os.ReadFile(claimed)
+		if err != nil {
+			return fmt.Errorf("read retention state: %w", err)
+		}
+		var sessions map[string]*pending
+		if err := json.Unmarshal(data, &sessions); err != nil {
+			return fmt.Errorf("parse retention state %s: %w", claimed, err)
+		}
+		for id, p := range sessions {
+			t.pending[id] = p
+		}
+		if claimed != t.path {
+			adopted = append(adopted, claimed)
+		}
+	}
+	if len(adopted) == 0 {
+		return nil
+	}
+	t.dirty = true
+	if err := t.save(); err != nil {
+		return err
+	}
+	for _, path := range adopted {
+		os.Remove(path)
+	}
+	return nil
+}
+
+// ownerPID returns the process a state file belongs to: the leading digits
+// of its name.
+func ownerPID(name string) (int, bool) {
+	end := strings.IndexFunc(name, func(r rune) bool { return r < '0' || r > '9' })
+	if end <= 0 {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(name[:end])
+	return pid, err == nil
+}
+
+// Observe records the edits proposed in msg and keeps its session from
+// being evaluated while it is active.
+func (t *Tracker) Observe(msg source.Message) {
+	if msg.SessionID == "" || msg.Direction == "outcome" {
+		return
+	}
+	proposals := extractProposals(msg.Raw, msg.Workspace)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.pending[msg.SessionID]
+	if !ok {
+		if len(proposals) == 0 {
+			return
+		}
+		p = &pending{SourceName: msg.SourceName, Workspace: msg.Workspace, Files: make(map[string]*fileState)}
+		t.pending[msg.SessionID] = p
+	}
+	p.LastActivity = time.Now()
+	for _, prop := range proposals {
+		f, ok := p.Files[prop.Path]
+		if !ok {
+			f = &fileState{}
+			p.Files[prop.Path] = f
+		}
+		f.record(prop)
+		t.dirty = true
+	}
+}
+
+// Close stops checking and persists what is still pending.
+func (t *Tracker) Close() {
+	close(t.stop)
+	<-t.done
+	if err := t.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "[recall/retention] %v\n", err)
+	}
+}
+
+// run checks pending evaluations until Close.
+func (t *Tracker) run() {
+	defer close(t.done)
+	t.check()
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			t.check()
+		}
+	}
+}
+
+// check evaluates every session that is due.
+func (t *Tracker) check() {
+	t.mu.Lock()
+	ids := make([]string, 0, len(t.pending))
+	for id := range t.pending {
+		ids = append(ids, id)
+	}
+	t.mu.Unlock()
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		t.mu.Lock()
+		p := t.pending[id]
+		workspace, lastActivity, head, headAt := p.Workspace, p.LastActivity, p.Head, p.HeadAt
+		t.mu.Unlock()
+
+		current := headOf(workspace)
+		trigger := ""
+		switch {
+		case lastActivity.After(headAt):
+			// Still active since the last check: HEAD moves now are the
+			// agent's own work, not the user's verdict.
+			t.mu.Lock()
+			p.Head, p.HeadAt = current, time.Now()
+			t.dirty = true
+			t.mu.Unlock()
+		case head != "" && current != "" && current != head:
+			trigger = "commit"
+		}
+		if trigger == "" && time.Since(lastActivity) >= t.config.Delay {
+			trigger = "delay"
+		}
+		if trigger == "" {
+			continue
+		}
+
+		t.mu.Lock()
+		if t.pending[id] != p || p.LastActivity != lastActivity {
+			t.mu.Unlock()
+			continue // new activity arrived while we looked
+		}
+		delete(t.pending, id)
+		t.dirty = true
+		t.mu.Unlock()
+
+		t.emit(id, p, evaluate(p, trigger))
+	}
+
+	if err := t.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "[recall/retention] %v\n", err)
+	}
+}
+
+// emit sends an outcome record for session id.
+func (t *Tracker) emit(id string, p *pending, out outcome) {
+	raw, err := json.Marshal(out)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[recall/retention] session %s: %d kept, %d modified, %d reverted\n",
+		id, out.Kept, out.Modified, out.Reverted)
+	t.config.Emit(source.Message{
+		Raw:        string(raw),
+		Direction:  "outcome",
+		SessionID:  id,
+		Workspace:  p.Workspace,
+		SourceName: p.SourceName,
+		CapturedAt: time.Now().UTC(),
+	})
+}
+
+// save persists pending evaluations atomically if anything changed. With
+// nothing pending, the state file is removed.
+func (t *Tracker) save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty {
+		return nil
+	}
+	if len(t.pending) == 0 {
+		if err := os.Remove(t.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove retention state: %w", err)
+		}
+		t.dirty = false
+		return nil
+	}
+	data, err := json.MarshalIndent(t.pending, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal retention state: %w", err)
+	}
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write retention state: %w", err)
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		return fmt.Errorf("replace retention state: %w", err)
+	}
+	t.dirty = false
+	return nil
+}
+
+// record folds one proposal into the file's state.
+func (f *fileState) record(p proposal) {
+	old := ""
+	if p.OldText != nil {
+		old = *p.OldText
+	}
+	added := subtract(lineHashes(p.NewText), lineHashes(old))
+	if !p.Full {
+		f.Proposed = ""
+		f.Added = append(f.Added, added...)
+		return
+	}
+
+	if p.OldText != nil && f.Original == "" && f.Proposed == "" && len(f.Added) == 0 {
+		f.Original = hash(old)
+	}
+	f.Proposed = hash(p.NewText)
+	// A full write supersedes earlier proposals: lines the agent added
+	// before count only if this version still has them, so revising its
+	// own work isn't scored as lost work.
+	newLines := lineHashes(p.NewText)
+	stillThere := subtract(f.Added, subtract(f.Added, newLines))
+	f.Added = append(stillThere, subtract(added, stillThere)...)
+}
+
+// evaluate compares every proposed file with the disk.
+func evaluate(p *pending, trigger string) outcome {
+	out := outcome{Type: "edit_retention", Trigger: trigger, Files: []fileOutcome{}}
+
+	paths := make([]string, 0, len(p.Files))
+	for path := range p.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		f := p.Files[path]
+		result := fileOutcome{Path: displayPath(p.Workspace, path), ProposedLines: len(f.Added)}
+
+		data, err := os.ReadFile(path)
+		exists := err == nil
+		current := string(data)
+		if exists {
+			result.RetainedLines = len(f.Added) - len(subtract(f.Added, lineHashes(current)))
+		}
+
+		switch {
+		case exists && f.Proposed != "" && hash(current) == f.Proposed:
+			result.Status = Kept
+			result.RetainedLines = result.ProposedLines
+		case !exists && f.Proposed == hash(""):
+			result.Status = Kept
+		case !exists, f.Original != "" && hash(current) == f.Original:
+			result.Status = Reverted
+			result.RetainedLines = 0
+		case result.ProposedLines == 0:
+			// Only deletions were proposed and the file differs from the
+			// proposal: nothing to count, so call it modified.
+			result.Status = Modified
+		case result.RetainedLines == result.ProposedLines && f.Proposed == "":
+			result.Status = Kept
+		case result.RetainedLines == 0:
+			result.Status = Reverted
+		default:
+			result.Status = Modified
+		}
+
+		switch result.Status {
+		case Kept:
+			out.Kept++
+		case Modified:
+			out.Modified++
+		case Reverted:
+			out.Reverted++
+		}
+		out.ProposedLines += result.ProposedLines
+		out.RetainedLines += result.RetainedLines
+		out.Files = append(out.Files, result)
+	}
+
+	if out.ProposedLines > 0 {
+		out.Retention = float64(out.RetainedLines) / float64(out.ProposedLines)
+	}
+	return out
+}
+
+// displayPath reports paths relative to the workspace when they are inside it.
+func displayPath(workspace, path string) string {
+	if workspace == "" {
+		return path
+	}
+	rel, err := filepath.Rel(workspace, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// lineHashes hashes every non-blank line, ignoring trailing whitespace.
+func lineHashes(text string) []string {
+	var hashes []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if strings.TrimSpace(line) != "" {
+			hashes = append(hashes, hash(line))
+		}
+	}
+	return hashes
+}
+
+// subtract returns the elements of a not matched by an element of b,
+// treating both as multisets.
+func subtract(a, b []string) []string {
+	counts := make(map[string]int, len(b))
+	for _, h := range b {
+		counts[h]++
+	}
+	var rest []string
+	for _, h := range a {
+		if counts[h] > 0 {
+			counts[h]--
+			continue
+		}
+		rest = append(rest, h)
+	}
+	return rest
+}
+
+// hash returns a short content hash. Collisions only blur the statistics.
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
+
+// headOf returns the commit HEAD points at in dir's repository, or "".
+func headOf(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	head, err := git.Run(dir, "rev-parse", "--verify", "--quiet", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return head
+}
@@ -0,0 +1,145 @@
+package retention
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shshwtsuthar/recall/source"
+)
+
+func ptr(s string) *string { return &s }
+
+func TestEvaluate(t *testing.T) {
+	const original = "package main\n\nfunc main() {}\n"
+	const proposed = "package main\n\nfunc main() {\n\tprintln(\"a\")\n\tprintln(\"b\")\n}\n"
+	const revised = "package main\n\nfunc main() {\n\tprintln(\"x\")\n}\n"
+
+	tests := []struct {
+		name      string
+		proposals []proposal
+		disk      *string // nil: the file does not exist
+		status    string
+		retained  int
+	}{
+		{
+			name:      "full write kept",
+			proposals: []proposal{{Full: true, OldText: ptr(original), NewText: proposed}},
+			disk:      ptr(proposed),
+			status:    Kept,
+			retained:  4,
+		},
+		{
+			name:      "full write partly kept",
+			proposals: []proposal{{Full: true, OldText: ptr(original), NewText: proposed}},
+			disk:      ptr("package main\n\nfunc main() {\n\tprintln(\"a\")\n}\n"),
+			status:    Modified,
+			retained:  3,
+		},
+		{
+			name:      "reverted to the original",
+			proposals: []proposal{{Full: true, OldText: ptr(original), NewText: proposed}},
+			disk:      ptr(original),
+			status:    Reverted,
+			retained:  0,
+		},
+		{
+			name:      "new file deleted",
+			proposals: []proposal{{Full: true, NewText: proposed}},
+			disk:      nil,
+			status:    Reverted,
+			retained:  0,
+		},
+		{
+			name: "partial edits kept",
+			proposals: []proposal{
+				{OldText: ptr("func main() {}"), NewText: "func main() {\n\tprintln(\"a\")\n\tprintln(\"b\")\n}"},
+			},
+			disk:     ptr(proposed),
+			status:   Kept,
+			retained: 4,
+		},
+		{
+			name: "rewrite of own work is not lost work",
+			proposals: []proposal{
+				{Full: true, OldText: ptr(original), NewText: revised},
+				{Full: true, OldText: ptr(revised), NewText: proposed},
+			},
+			disk:     ptr(proposed),
+			status:   Kept,
+			retained: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "main.go")
+			if tt.disk != nil {
+				if err := os.WriteFile(path, []byte(*tt.disk), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f := &fileState{}
+			for _, prop := range tt.proposals {
+				prop.Path = path
+				f.record(prop)
+			}
+			p := &pending{Workspace: dir, Files: map[string]*fileState{path: f}}
+
+			out := evaluate(p, "delay")
+			if len(out.Files) != 1 {
+				t.Fatalf("got %d files, want 1", len(out.Files))
+			}
+			got := out.Files[0]
+			if got.Path != "main.go" || got.Status != tt.status || got.RetainedLines != tt.retained {
+				t.Errorf("got %+v, want main.go %s with %d retained lines", got, tt.status, tt.retained)
+			}
+		})
+	}
+}
+
+func TestAdoptsStateOfExitedProcesses(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, session string) {
+		data, err := json.Marshal(map[string]*pending{
+			session: {SourceName: "test", LastActivity: time.Now(), Files: map[string]*fileState{}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A pid above the kernel's limit can't belong to a running process.
+	exited := strconv.Itoa(1<<22+1) + ".json"
+	running := strconv.Itoa(os.Getppid()) + ".json"
+	write(exited, "orphaned")
+	write(running, "theirs")
+
+	tracker, err := New(Config{StateDir: dir, Delay: time.Hour, Emit: func(source.Message) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Close()
+
+	tracker.mu.Lock()
+	_, orphaned := tracker.pending["orphaned"]
+	_, theirs := tracker.pending["theirs"]
+	tracker.mu.Unlock()
+	if !orphaned || theirs {
+		t.Errorf("adopted orphaned=%v theirs=%v, want only the orphaned session", orphaned, theirs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, exited)); !os.IsNotExist(err) {
+		t.Errorf("state of the exited process still exists: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, running)); err != nil {
+		t.Errorf("state of the running process: %v", err)
+	}
+	if _, err := os.Stat(tracker.path); err != nil {
+		t.Errorf("adopted sessions not saved: %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package transmitter
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/shshwtsuthar/recall/internal/metrics"
+)
+
+// Blob deduplication.
+//
+// Agents read the same large files over and over, and each read would
+// otherwise be transmitted in full. With a blob store configured (see
+// UseBlobStore), every JSON string value in Raw at least minBytes long is
+// moved out of the message into a content-addressed blob:
+//
+//   - the value is replaced in Raw by the string "<BLOB:sha256:HEX>";
+//   - the blob is the value exactly as it appeared in Raw, without its
+//     quotes and still JSON-escaped, and HEX is its SHA-256. Putting it
+//     back between the quotes restores Raw byte for byte, however the
+//     agent escaped it;
+//   - the blob is uploaded once: HEAD <blob URL>/<HEX> asks whether the
+//     server has it (2xx: yes, 404: no), and PUT <blob URL>/<HEX> with the
+//     blob as body stores it;
+//   - Payload.Blobs lists the hashes the message references.
+//
+// The rest of Raw is left byte for byte as it was. A message is only sent
+// after its blobs are confirmed stored; if a blob can't be stored, the
+// message is sent with its content inline instead, so the server never sees
+// a reference it can't resolve.
+
+// BlobPrefix starts the placeholder that replaces a deduplicated value.
+const BlobPrefix = "<BLOB:sha256:"
+
+// DefaultBlobMinBytes is the value size from which UseBlobStore moves
+// values to blobs when given 0.
+const DefaultBlobMinBytes = 4096
+
+// maxKnownBlobs bounds the set of hashes known to be on the server.
+const maxKnownBlobs = 100000
+
+var (
+	blobUploads = metrics.NewCounter("recall_blob_uploads_total",
+		"Blobs uploaded to the server.")
+	blobBytesSaved = metrics.NewCounter("recall_blob_bytes_deduplicated_total",
+		"Bytes not transmitted because the server already had the blob.")
+)
+
+// blobStore tracks which blobs the server has.
+type blobStore struct {
+	url      string
+	minBytes int
+	http     *http.Client
+	ctx      context.Context // the Client's; cancelled by Close
+
+	mu      sync.Mutex
+	known   map[string]bool        // hashes the server has
+	pending map[string]*blobUpload // uploads in progress, by hash
+}
+
+// blobUpload is one in-progress check-and-upload, shared by every send that
+// references the blob.
+type blobUpload struct {
+	done chan struct{}
+	err  error
+}
+
+// UseBlobStore enables blob deduplication for string values of at least
+// minBytes bytes, against the blob endpoint at url (e.g.
+// "https://hivemind.yourdomain.com/blobs"). A minBytes of 0 means
+// DefaultBlobMinBytes. Call it before the first Send.
+func (c *Client) UseBlobStore(url string, minBytes int) {
+	if minBytes <= 0 {
+		minBytes = DefaultBlobMinBytes
+	}
+	c.blobs = &blobStore{
+		url:      strings.TrimRight(url, "/"),
+		minBytes: minBytes,
+		http:     c.httpClient,
+		ctx:      c.ctx,
+		known:    make(map[string]bool),
+		pending:  make(map[string]*blobUpload),
+	}
+}
+
+// dedupe returns payload with its large values moved to blobs, after
+// making sure the server has them. On failure it returns payload unchanged.
+func (s *blobStore) dedupe(payload Payload) Payload {
+	raw, blobs := splitBlobs(payload.Raw, s.minBytes)
+	if len(blobs) == 0 {
+		return payload
+	}
+	for hash, content := range blobs {
+		if err := s.ensure(hash, content); err != nil {
+			if s.ctx.Err() != nil {
+				return payload // cancelled by Close; the send fails too
+			}
+			fmt.Fprintf(os.Stderr, "[recall/transmit] blob upload failed, sending inline: %v\n", err)
+			return payload
+		}
+	}
+	payload.Raw = raw
+	payload.Blobs = make([]string, 0, len(blobs))
+	for hash := range blobs {
+		payload.Blobs = append(payload.Blobs, hash)
+	}
+	sort.Strings(payload.Blobs)
+	return payload
+}
+
+// ensure makes sure the server has the blob, uploading it if needed.
+// Concurrent calls for one hash share a single check and upload.
+func (s *blobStore) ensure(hash string, content []byte) error {
+	s.mu.Lock()
+	if s.known[hash] {
+		s.mu.Unlock()
+		blobBytesSaved.Add(float64(len(content)))
+		return nil
+	}
+	if up, ok := s.pending[hash]; ok {
+		s.mu.Unlock()
+		<-up.done
+		return up.err
+	}
+	up := &blobUpload{done: make(chan struct{})}
+	s.pending[hash] = up
+	s.mu.Unlock()
+
+	uploaded, err := s.upload(hash, content)
+	up.err = err
+
+	s.mu.Lock()
+	delete(s.pending, hash)
+	if err == nil {
+		if len(s.known) >= maxKnownBlobs {
+			clear(s.known)
+		}
+		s.known[hash] = true
+	}
+	s.mu.Unlock()
+	close(up.done)
+
+	if err == nil && !uploaded {
+		blobBytesSaved.Add(float64(len(content)))
+	}
+	return err
+}
+
+// upload checks whether the server has the blob and stores it if not. It
+// reports whether the content was transmitted.
+func (s *blobStore) upload(hash string, content []byte) (bool, error) {
+	url := s.url + "/" + hash
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("check blob: %w", err)
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("check blob: %w", err)
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode != http.StatusNotFound:
+		return false, fmt.Errorf("check blob: server returned %d", resp.StatusCode)
+	}
+
+	req, err = http.NewRequestWithContext(s.ctx, http.MethodPut, url, bytes.NewReader(content))
+	if err != nil {
+		return false, fmt.Errorf("upload blob: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err = s.http.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("upload blob: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return false, fmt.Errorf("upload blob: server returned %d", resp.StatusCode)
+	}
+	blobUploads.Inc()
+	return true, nil
+}
+
+// splitBlobs replaces every JSON string value in raw whose encoded form is
+// at least minBytes long with a blob placeholder. Object keys are never
+// replaced, and everything but the replaced literals is copied byte for
+// byte. It returns the new raw and the blobs, the literals without their
+// quotes, by hash. Raw that isn't JSON is returned as is.
+func splitBlobs(raw string, minBytes int) (string, map[string][]byte) {
+	if len(raw) < minBytes || !json.Valid([]byte(raw)) {
+		return raw, nil
+	}
+	var (
+		out   strings.Builder
+		blobs map[string][]byte
+		last  int // end of the part of raw already copied to out
+	)
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '"' {
+			continue
+		}
+		// Outside strings, a quote always opens one; find its end.
+		end := i + 1
+		for raw[end] != '"' {
+			if raw[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		literal := raw[i : end+1]
+		if len(literal) >= minBytes && !isKey(raw, end+1) {
+			value := literal[1 : len(literal)-1]
+			sum := sha256.Sum256([]byte(value))
+			hash := hex.EncodeToString(sum[:])
+			if blobs == nil {
+				blobs = make(map[string][]byte)
+				out.Grow(len(raw))
+			}
+			blobs[hash] = []byte(value)
+			out.WriteString(raw[last:i])
+			out.WriteString(`"` + BlobPrefix + hash + `>"`)
+			last = end + 1
+		}
+		i = end
+	}
+	if blobs == nil {
+		return raw, nil
+	}
+	out.WriteString(raw[last:])
+	return out.String(), blobs
+}
+
+// isKey reports whether the string literal ending just before pos is an
+// object key, that is, followed by a colon.
+func isKey(raw string, pos int) bool {
+	for ; pos < len(raw); pos++ {
+		switch raw[pos] {
+		case ' ', '\t', '\n', '\r':
+			continue
+		case ':':
+			return true
+		}
+		return false
+	}
+	return false
+}
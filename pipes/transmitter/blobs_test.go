@@ -0,0 +1,60 @@
+package transmitter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSplitBlobsRoundTrip(t *testing.T) {
+	large := strings.Repeat("file contents\n", 10)
+	// Escaped the way other encoders do it, not the way json.Marshal would.
+	odd := strings.Repeat(`<a href=\"http:\/\/x\/\">caf\u00e9 café</a> \u2028 \ud83d\ude00 `, 4) + "\xff"
+	longKey := strings.Repeat("k", 200)
+	raw := `{ "jsonrpc":"2.0", "id" : 7,` + "\n\t" +
+		`"params": {"` + longKey + `": "short", "text" :` + quote(large) + `,` +
+		`"html": "` + odd + `",` +
+		`"list":[ ` + quote(large+"2") + ` , "small", 1.50, null ]}}`
+
+	out, blobs := splitBlobs(raw, 100)
+	if len(blobs) != 3 {
+		t.Fatalf("got %d blobs, want 3", len(blobs))
+	}
+	if !strings.Contains(out, `"`+longKey+`"`) {
+		t.Errorf("object key was replaced: %s", out)
+	}
+	if !json.Valid([]byte(out)) {
+		t.Errorf("result is not JSON: %s", out)
+	}
+
+	// Putting the blobs back between the quotes must give the original bytes.
+	restored := out
+	for hash, content := range blobs {
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != hash {
+			t.Errorf("blob %s has a different hash", hash)
+		}
+		restored = strings.ReplaceAll(restored, BlobPrefix+hash+">", string(content))
+	}
+	if restored != raw {
+		t.Errorf("round trip changed raw\n got: %s\nwant: %s", restored, raw)
+	}
+}
+
+func TestSplitBlobsKeepsSmallAndInvalid(t *testing.T) {
+	for _, raw := range []string{
+		`{"a":"b"}`,
+		`not json "` + strings.Repeat("x", 200) + `"`,
+	} {
+		if out, blobs := splitBlobs(raw, 100); out != raw || blobs != nil {
+			t.Errorf("splitBlobs(%q) = %q, %d blobs; want it unchanged", raw, out, len(blobs))
+		}
+	}
+}
+
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
@@ -4,25 +4,105 @@
 //   - Transmission must NEVER stall the message pipeline. If the server is slow,
 //     unreachable, or returns an error, the user's session is unaffected.
 //   - Each call to Send() is non-blocking. It spawns a goroutine internally.
-//   - Failed transmissions are logged to stderr (visible in IDE dev consoles)
-//     but are otherwise silently dropped.
+//   - Network errors and 429/5xx responses are retried a couple of times;
+//     failed transmissions are then logged to stderr (visible in IDE dev
+//     consoles) but are otherwise silently dropped.
+//   - Sends still in flight at shutdown are waited for (see Close); those that
+//     miss the deadline are cancelled and can be spooled to disk and resent
+//     on the next start.
 package transmitter
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
+
+	"github.com/shshwtsuthar/recall/internal/metrics"
+)
+
+// maxRetries is how many times a send is retried after a network error or
+// a 429/5xx response. Other failures are not retried.
+const maxRetries = 2
+
+// retryBackoff is the wait before the first retry; it doubles each time.
+const retryBackoff = 500 * time.Millisecond
+
+var (
+	sendDuration = metrics.NewHistogram("recall_send_duration_seconds",
+		"Time to deliver one message to the server, including retries.",
+		[]float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10})
+	sendResponses = metrics.NewCounter("recall_send_responses_total",
+		"Send attempts by outcome: HTTP status class (2xx, 4xx, 5xx) or \"error\" for network errors.",
+		"class")
+	sendRetries = metrics.NewCounter("recall_send_retries_total",
+		"Send attempts that were retries.")
+	sendResults = metrics.NewCounter("recall_sends_total",
+		"Messages whose delivery finished, by result (sent, failed).",
+		"result")
+	inflightSends = metrics.NewGauge("recall_sends_in_flight",
+		"Messages handed to the transmitter and not yet delivered or failed.",
+		func() float64 {
+			clientsMu.Lock()
+			defer clientsMu.Unlock()
+			n := 0
+			for c := range clients {
+				c.mu.Lock()
+				n += len(c.inflight)
+				c.mu.Unlock()
+			}
+			return float64(n)
+		})
 )
 
+// clients are the live Clients, for the in-flight gauge.
+var (
+	clientsMu sync.Mutex
+	clients   = make(map[*Client]struct{})
+)
+
+// Health summarizes transmission since startup, for status output.
+type Health struct {
+	Sent, Failed, Retries int
+	InFlight              int
+	P50, P95              time.Duration // send latency estimates; 0 if no sends yet
+}
+
+// CurrentHealth reads the transmitter metrics.
+func CurrentHealth() Health {
+	results := sendResults.Values()
+	h := Health{
+		Sent:     int(results["sent"]),
+		Failed:   int(results["failed"]),
+		Retries:  int(sendRetries.Total()),
+		InFlight: int(inflightSends.Value()),
+	}
+	if sendDuration.Count() > 0 {
+		h.P50 = time.Duration(sendDuration.Quantile(0.5) * float64(time.Second))
+		h.P95 = time.Duration(sendDuration.Quantile(0.95) * float64(time.Second))
+	}
+	return h
+}
+
 // Payload is the JSON body sent to the main server for each message.
 type Payload struct {
 	// Direction indicates message flow.
 	// "upstream" = IDE/User to Agent (user prompts, context)
 	// "downstream" = Agent to IDE/User (responses, tool calls, thoughts)
 	// "log" = Unidirectional log entries (e.g., from file tailing)
+	// "model_request" / "model_response" = Agent to/from its LLM API
+	// "git" = Commits and working-tree changes linked to the session
+	// "outcome" = Labels computed after the session (e.g. edit retention)
+	// "control" = Records about capture itself (e.g. a suppressed session)
 	Direction string `json:"direction"`
 
 	// Raw is the scrubbed message content as it was captured exactly.
@@ -37,74 +117,373 @@ type Payload struct {
 	// Useful for the adapter layer to know which protocol parser to use.
 	SourceName string `json:"source_name"`
 
-	// CapturedAt is the RFC3339 timestamp when this message was intercepted.
+	// CapturedAt is the RFC3339 timestamp when the source intercepted this
+	// message (not when it was sent).
 	CapturedAt string `json:"captured_at"`
+
+	// ConnectionID identifies the recall process that sent the payload. It
+	// is random and generated at startup; Seq numbers are scoped to it.
+	ConnectionID string `json:"connection_id"`
+
+	// Seq numbers the payloads of one session, per source and connection,
+	// from 1 in the order they were captured. Payloads arrive at the server
+	// in any order (each is sent by its own goroutine, and failed sends are
+	// retried); sorting by Seq restores the original order, and a gap
+	// means a payload was lost. Messages captured before a session ID was
+	// known are numbered under the empty session ID.
+	//
+	// A payload can arrive more than once: a retried send may have reached
+	// the server the first time, and `recall-proxy upload` resends stored
+	// payloads unchanged. Servers should deduplicate on (ConnectionID,
+	// SourceName, SessionID, Seq).
+	Seq uint64 `json:"seq"`
+
+	// Meta holds labels describing the capture environment (machine, agent,
+	// repository), all hashed where identifying. See pipes/enrich.
+	Meta map[string]string `json:"meta,omitempty"`
+
+	// Blobs lists the SHA-256 hashes of the blobs Raw references, when
+	// large values were moved out of it (see UseBlobStore).
+	Blobs []string `json:"blobs,omitempty"`
 }
 
 // Client is a configured transmitter. Create one at startup and reuse it.
-// It is safe to call Send() from multiple goroutines concurrently.
+// It is safe to call Send() from multiple goroutines concurrently, and one
+// Client serves every source in the process: the source name travels with
+// each payload.
 type Client struct {
 	serverURL  string
-	sourceName string
 	httpClient *http.Client
+	blobs      *blobStore // nil unless UseBlobStore was called
+	stamper    *Stamper
+
+	// ctx is cancelled by Close once its deadline passes, aborting the
+	// requests still in flight.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	inflight map[uint64]Payload // sends that haven't finished, by send number
+	next     uint64
+	closed   bool
+	sent     int
+	failed   int
+}
+
+// Stats reports what happened to the sends in flight when Close was called.
+type Stats struct {
+	// InFlight is how many sends had not finished when Close was called.
+	InFlight int
+
+	// Flushed and Failed count those that finished before the deadline,
+	// successfully or not.
+	Flushed int
+	Failed  int
+
+	// Unsent holds the payloads still in flight at the deadline, whose
+	// sends were then cancelled, so the caller can persist them (see
+	// SaveSpool).
+	Unsent []Payload
 }
 
 // New creates a transmitter Client.
 //
 //   - serverURL: the full URL of your hive mind ingest endpoint,
 //     e.g. "https://hivemind.yourdomain.com/ingest"
-//   - sourceName: the name of the source producing messages, e.g. "acp", "claude-cli"
-func New(serverURL, sourceName string) *Client {
-	return &Client{
-		serverURL:  serverURL,
-		sourceName: sourceName,
+func New(serverURL string) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Client{
+		serverURL: serverURL,
 		httpClient: &http.Client{
 			// Hard timeout: if the server doesn't respond in 5 seconds, drop it.
 			// The pipeline cannot wait longer than this in the worst case where Send
 			// is called synchronously — but see Send() below, it's async.
 			Timeout: 5 * time.Second,
 		},
+		stamper:  NewStamper(),
+		ctx:      ctx,
+		cancel:   cancel,
+		inflight: make(map[uint64]Payload),
 	}
+	clientsMu.Lock()
+	clients[c] = struct{}{}
+	clientsMu.Unlock()
+	return c
 }
 
-// Send queues a scrubbed message for transmission to the server.
+// Send queues a scrubbed message for transmission to the server, stamping
+// it with the connection ID and its session's next Seq, and CapturedAt with
+// the current time if it is empty. Seq follows the order of Send calls, so
+// call it in capture order.
 // It returns immediately — transmission happens in a background goroutine.
 // The message pipeline is never blocked by network latency or server errors.
-func (c *Client) Send(direction, sessionID, raw string) {
-	payload := Payload{
-		Direction:  direction,
-		Raw:        raw,
-		SessionID:  sessionID,
-		SourceName: c.sourceName,
-		CapturedAt: time.Now().UTC().Format(time.RFC3339Nano),
+func (c *Client) Send(payload Payload) {
+	c.enqueue(c.stamper.Stamp(payload))
+}
+
+// Deliver sends a payload that was stamped earlier, such as one read back
+// from the local store, and waits for the result. Unlike Send, it keeps
+// the payload's CapturedAt, ConnectionID and Seq.
+func (c *Client) Deliver(payload Payload) error {
+	err := c.send(payload)
+	if err != nil {
+		sendResults.Inc("failed")
+	} else {
+		sendResults.Inc("sent")
 	}
+	return err
+}
+
+// Stamper numbers payloads the way Send does, for sinks other than a
+// Client. It is safe for concurrent use.
+type Stamper struct {
+	connectionID string
+	mu           sync.Mutex
+	seqs         map[streamKey]uint64 // last Seq handed out per session
+}
+
+// streamKey identifies the payloads numbered by one Seq counter.
+type streamKey struct {
+	sourceName, sessionID string
+}
+
+// NewStamper returns a Stamper with a new connection ID.
+func NewStamper() *Stamper {
+	return &Stamper{connectionID: newConnectionID(), seqs: make(map[streamKey]uint64)}
+}
 
-	// Fire and forget. The goroutine owns the payload; no shared state.
+// Stamp returns payload with the connection ID and its session's next Seq,
+// and CapturedAt set to the current time if it is empty.
+func (s *Stamper) Stamp(payload Payload) Payload {
+	if payload.CapturedAt == "" {
+		payload.CapturedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	payload.ConnectionID = s.connectionID
+
+	key := streamKey{payload.SourceName, payload.SessionID}
+	s.mu.Lock()
+	s.seqs[key]++
+	payload.Seq = s.seqs[key]
+	s.mu.Unlock()
+	return payload
+}
+
+// newConnectionID returns a random identifier for this process's payloads.
+func newConnectionID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		// crypto/rand doesn't fail on supported platforms; fall back to
+		// something still unique enough to scope Seq.
+		return fmt.Sprintf("%x-%d", time.Now().UnixNano(), os.Getpid())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// enqueue starts transmitting payload in the background.
+func (c *Client) enqueue(payload Payload) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		fmt.Fprintf(os.Stderr, "[recall/transmit] dropped a message sent after shutdown\n")
+		return
+	}
+	seq := c.next
+	c.next++
+	c.inflight[seq] = payload
+	c.wg.Add(1)
+	c.mu.Unlock()
+
+	// Fire and forget. The goroutine owns the payload; the in-flight table
+	// only exists so Close can wait for it.
 	go func() {
-		if err := c.send(payload); err != nil {
+		defer c.wg.Done()
+		err := c.send(payload)
+		if err != nil && c.ctx.Err() == nil {
 			// Log to stderr. This surfaces in IDE dev consoles.
 			// We never write to stdout — that may be reserved for forwarding.
 			fmt.Fprintf(os.Stderr, "[recall/transmit] error: %v\n", err)
 		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err != nil && c.ctx.Err() != nil {
+			return // cancelled by Close, which reports it as unsent
+		}
+		delete(c.inflight, seq)
+		if err != nil {
+			c.failed++
+			sendResults.Inc("failed")
+		} else {
+			c.sent++
+			sendResults.Inc("sent")
+		}
 	}()
 }
 
-// send performs the actual HTTP POST. Called inside a goroutine by Send().
+// Close stops accepting messages and waits, until ctx is done, for the
+// sends already in flight. Those still in flight at the deadline are then
+// cancelled, so none of them can complete after being reported unsent. It
+// returns what became of them; the cancelled payloads are in Stats.Unsent.
+func (c *Client) Close(ctx context.Context) Stats {
+	c.mu.Lock()
+	c.closed = true
+	stats := Stats{InFlight: len(c.inflight)}
+	sent, failed := c.sent, c.failed
+	c.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		c.cancel()
+		<-done
+	}
+	c.cancel()
+
+	clientsMu.Lock()
+	delete(clients, c)
+	clientsMu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats.Flushed = c.sent - sent
+	stats.Failed = c.failed - failed
+	for _, payload := range c.inflight {
+		stats.Unsent = append(stats.Unsent, payload)
+	}
+	return stats
+}
+
+// SpoolDir returns the spool directory for serverURL under dir. Each server
+// has its own, so payloads are only ever resent to the server they were
+// meant for.
+func SpoolDir(dir, serverURL string) string {
+	sum := sha256.Sum256([]byte(serverURL))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8]))
+}
+
+// spoolExt is the extension of a complete spool file.
+const spoolExt = ".jsonl"
+
+// SaveSpool writes payloads, one JSON object per line, to a new file in the
+// spool directory dir for ResendSpool to pick up on the next start. The file
+// only appears once it is complete.
+func SaveSpool(dir string, payloads []Payload) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create spool: %w", err)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, payload := range payloads {
+		if err := enc.Encode(payload); err != nil {
+			return fmt.Errorf("write spool: %w", err)
+		}
+	}
+	name := fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+	tmp := filepath.Join(dir, name+".tmp")
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("write spool: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name+spoolExt)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write spool: %w", err)
+	}
+	return nil
+}
+
+// ResendSpool sends the payloads saved in the spool directory dir by earlier
+// runs, keeping their original timestamps, connection IDs and Seqs, and
+// removes their files. A missing directory is not an error. It returns how
+// many payloads were queued.
+//
+// Every file is claimed by renaming it before it is read, so when several
+// processes start at once each file is resent by exactly one of them.
+func (c *Client) ResendSpool(dir string) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+spoolExt))
+	if err != nil {
+		return 0, fmt.Errorf("read spool: %w", err)
+	}
+	n := 0
+	for _, path := range paths {
+		claimed := path + "." + strconv.Itoa(os.Getpid())
+		if err := os.Rename(path, claimed); err != nil {
+			continue // claimed by another process
+		}
+		data, err := os.ReadFile(claimed)
+		if err != nil {
+			return n, fmt.Errorf("read spool: %w", err)
+		}
+		// Remove first: if we crash while resending, the sends in flight
+		// are lost rather than duplicated on every start.
+		if err := os.Remove(claimed); err != nil {
+			return n, fmt.Errorf("remove spool: %w", err)
+		}
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			var payload Payload
+			if len(bytes.TrimSpace(line)) == 0 || json.Unmarshal(line, &payload) != nil {
+				continue
+			}
+			c.enqueue(payload)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// send delivers one payload, retrying transient failures. Called inside a
+// goroutine by enqueue.
 func (c *Client) send(payload Payload) error {
+	start := time.Now()
+	defer func() { sendDuration.Observe(time.Since(start).Seconds()) }()
+
+	if c.blobs != nil {
+		payload = c.blobs.dedupe(payload)
+	}
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("marshal payload: %w", err)
 	}
 
-	resp, err := c.httpClient.Post(c.serverURL, "application/json", bytes.NewReader(body))
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := c.post(body)
+		if err == nil || !retry || attempt == maxRetries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-c.ctx.Done():
+			return err
+		}
+		backoff *= 2
+		sendRetries.Inc()
+	}
+}
+
+// post performs one HTTP POST and reports whether a failure is worth retrying.
+func (c *Client) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, c.serverURL, bytes.NewReader(body))
 	if err != nil {
-		return fmt.Errorf("http post: %w", err)
+		return false, fmt.Errorf("http post: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		sendResponses.Inc("error")
+		return true, fmt.Errorf("http post: %w", err)
 	}
 	defer resp.Body.Close()
 
+	sendResponses.Inc(fmt.Sprintf("%dxx", resp.StatusCode/100))
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("server returned %d", resp.StatusCode)
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("server returned %d", resp.StatusCode)
 	}
 
-	return nil
+	return false, nil
 }
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "github.com/shshwtsuthar/recall/pipes/pause"
+
+// watchPauseSignals does nothing: there are no user signals on this
+// platform. Use `recall-proxy pause` instead.
+func watchPauseSignals(*pause.Switch) {}
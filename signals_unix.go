@@ -0,0 +1,26 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/shshwtsuthar/recall/pipes/pause"
+)
+
+// watchPauseSignals pauses capture on SIGUSR1 and resumes it on SIGUSR2.
+func watchPauseSignals(sw *pause.Switch) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGUSR1 {
+				sw.Pause("")
+			} else {
+				sw.Resume("")
+			}
+		}
+	}()
+}
@@ -1,16 +1,15 @@
 package acp
 
 import (
-	"bufio"
 	"context"
 	"fmt"
-	"io"
-	"os"
-	"os/exec"
 	"sync"
-	"time"
 
+	"github.com/shshwtsuthar/recall/internal/appdir"
+	"github.com/shshwtsuthar/recall/internal/prompt"
 	"github.com/shshwtsuthar/recall/source"
+	"github.com/shshwtsuthar/recall/source/mitm"
+	"github.com/shshwtsuthar/recall/source/stdioproxy"
 )
 
 // Config holds ACP-specific configuration.
@@ -18,6 +17,20 @@ type Config struct {
 	// AgentArgs is the agent binary and its arguments.
 	// Example: ["claude", "--experimental-acp"]
 	AgentArgs []string
+
+	// CaptureLLM routes the agent's HTTPS traffic through a local forward
+	// proxy and captures its model API exchanges (see package mitm).
+	// Opt-in: it changes the agent's environment and trust store.
+	CaptureLLM bool
+
+	// LLMHosts overrides the model API hosts intercepted when CaptureLLM is set.
+	LLMHosts []string
+
+	// StripDirectives, if set, is the directive prefix ("#recall") whose
+	// lines are removed from prompts before they reach the agent (see
+	// package prompt). Opt-in: it is the one case where the proxy
+	// modifies traffic.
+	StripDirectives string
 }
 
 // Source implements the source.Source interface for ACP agents.
@@ -32,6 +45,11 @@ func New(config Config) *Source {
 	return &Source{config: config}
 }
 
+// AgentCommand implements source.Launcher.
+func (s *Source) AgentCommand() []string {
+	return s.config.AgentArgs
+}
+
 // Name returns the identifier for this source type.
 func (s *Source) Name() string {
 	return "acp"
@@ -39,177 +57,108 @@ func (s *Source) Name() string {
 
 // Run spawns the ACP agent subprocess and intercepts bidirectional stdio traffic.
 //
-// Architecture:
-//  1. Spawns agent as subprocess with exec.CommandContext (respects ctx cancellation)
-//  2. Wires stdin/stdout pipes (stderr passes through to os.Stderr)
-//  3. Launches two goroutines:
-//     - Upstream: os.Stdin → emit Message → agent stdin
-//     - Downstream: agent stdout → extract sessionID → emit Message → os.Stdout
-//  4. Waits for both goroutines and subprocess to complete
-//  5. Closes the output channel (ownership model)
+// The process and pipe handling lives in package stdioproxy; this source only
+// contributes what is ACP-specific: newline-delimited JSON-RPC framing and
+// session tracking from session/new and session/load exchanges.
 //
 // The IDE and agent see unmodified ACP traffic — they are completely unaware
 // of the proxy's presence. We just observe and emit messages for the pipeline.
+// The one exception is opt-in: with StripDirectives set, directive lines are
+// removed from prompts on their way to the agent.
 func (s *Source) Run(ctx context.Context, out chan<- source.Message) error {
 	if len(s.config.AgentArgs) == 0 {
+		close(out)
 		return fmt.Errorf("no agent command specified")
 	}
 
-	agentBinary := s.config.AgentArgs[0]
-	agentCmdArgs := s.config.AgentArgs[1:]
-
-	// Spawn the real agent as a subprocess with context for cancellation.
-	cmd := exec.CommandContext(ctx, agentBinary, agentCmdArgs...)
-
-	// Wire up the agent's stdin and stdout.
-	// cmd.Stderr is passed through directly — agent error output goes straight
-	// to our stderr, which is visible in the IDE's dev console.
-	agentStdin, err := cmd.StdinPipe()
-	if err != nil {
-		return fmt.Errorf("create agent stdin pipe: %w", err)
+	proxyConfig := stdioproxy.Config{
+		Name:    s.Name(),
+		Command: s.config.AgentArgs,
+		Framing: stdioproxy.LineFraming,
+		Hooks: stdioproxy.Hooks{
+			ExtractSession: newSessionTracker().extract,
+		},
 	}
-	agentStdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("create agent stdout pipe: %w", err)
+	if prefix := s.config.StripDirectives; prefix != "" {
+		proxyConfig.Hooks.Rewrite = func(direction, payload string) string {
+			if direction != stdioproxy.Upstream {
+				return payload
+			}
+			stripped, _ := prompt.Strip(prefix, payload)
+			return stripped
+		}
 	}
-	cmd.Stderr = os.Stderr
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("start agent %q: %w", agentBinary, err)
+	if !s.config.CaptureLLM {
+		return stdioproxy.New(proxyConfig).Run(ctx, out)
 	}
 
-	// Session tracking: sessionID groups all messages in one conversation.
-	// It is updated when we see a session/new response from the agent.
-	// Protected by mutex since both goroutines may read it.
+	// With model capture on, two producers share out: the stdio proxy and the
+	// forward proxy. The stdio proxy gets its own channel (it closes it when
+	// done) and out is closed here once both producers have stopped.
 	var (
-		sessionID string
-		sessionMu sync.RWMutex
+		outMu  sync.RWMutex
+		closed bool
 	)
-
-	getSessionID := func() string {
-		sessionMu.RLock()
-		defer sessionMu.RUnlock()
-		return sessionID
+	emit := func(msg source.Message) {
+		outMu.RLock()
+		defer outMu.RUnlock()
+		if closed {
+			return
+		}
+		select {
+		case out <- msg:
+		case <-ctx.Done():
+		}
 	}
-
-	setSessionID := func(id string) {
-		sessionMu.Lock()
-		defer sessionMu.Unlock()
-		sessionID = id
+	closeOut := func() {
+		outMu.Lock()
+		defer outMu.Unlock()
+		closed = true
+		close(out)
 	}
 
-	// done is closed when either pipe goroutine finishes, signaling the other
-	// to stop. This prevents goroutine leaks if one side closes early.
-	done := make(chan struct{})
-	closeOnce := sync.Once{}
-	signalDone := func() { closeOnce.Do(func() { close(done) }) }
-
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	// -------------------------------------------------------------------------
-	// Goroutine A: UPSTREAM — IDE → proxy → agent
-	// Reads from os.Stdin (written by IDE), emits messages, forwards to agent.
-	// -------------------------------------------------------------------------
-	go func() {
-		defer wg.Done()
-		defer signalDone()
-		defer agentStdin.Close()
-
-		scanner := newScanner(os.Stdin)
-		for scanner.Scan() {
-			select {
-			case <-done:
-				return
-			case <-ctx.Done():
-				return
-			default:
-			}
-
-			line := scanner.Text()
-
-			// Emit message for pipeline processing.
-			out <- source.Message{
-				Raw:        line,
-				Direction:  "upstream",
-				SessionID:  getSessionID(),
-				SourceName: s.Name(),
-				CapturedAt: time.Now().UTC(),
-			}
+	caDir, err := appdir.Path("mitm")
+	if err != nil {
+		closeOut()
+		return err
+	}
+	ca, err := mitm.LoadOrCreateCA(caDir)
+	if err != nil {
+		closeOut()
+		return err
+	}
 
-			// Forward ORIGINAL (unmodified) line to agent.
-			// The agent must see real ACP messages.
-			fmt.Fprintln(agentStdin, line)
-		}
+	var proxy *stdioproxy.Proxy
+	forward, err := mitm.Start(mitm.Config{
+		SourceName: s.Name(),
+		CA:         ca,
+		Hosts:      s.config.LLMHosts,
+		SessionID:  func() string { return proxy.SessionID() },
+		Emit:       emit,
+	})
+	if err != nil {
+		closeOut()
+		return fmt.Errorf("start model capture proxy: %w", err)
+	}
 
-		if err := scanner.Err(); err != nil && err != io.EOF {
-			fmt.Fprintf(os.Stderr, "[recall/acp] upstream read error: %v\n", err)
-		}
-	}()
+	proxyConfig.Env = forward.Env()
+	proxy = stdioproxy.New(proxyConfig)
 
-	// -------------------------------------------------------------------------
-	// Goroutine B: DOWNSTREAM — agent → proxy → IDE
-	// Reads from agent stdout, extracts session ID, emits messages, forwards to IDE.
-	// -------------------------------------------------------------------------
+	proxyOut := make(chan source.Message, cap(out))
+	relayed := make(chan struct{})
 	go func() {
-		defer wg.Done()
-		defer signalDone()
-
-		scanner := newScanner(agentStdout)
-		for scanner.Scan() {
-			select {
-			case <-done:
-				return
-			case <-ctx.Done():
-				return
-			default:
-			}
-
-			line := scanner.Text()
-
-			// Attempt to extract session ID from session/new responses.
-			// This is best-effort — we never modify the line based on this.
-			if id := extractSessionID(line); id != "" {
-				setSessionID(id)
-				fmt.Fprintf(os.Stderr, "[recall/acp] session started: %s\n", id)
-			}
-
-			// Emit message for pipeline processing.
-			out <- source.Message{
-				Raw:        line,
-				Direction:  "downstream",
-				SessionID:  getSessionID(),
-				SourceName: s.Name(),
-				CapturedAt: time.Now().UTC(),
-			}
-
-			// Forward ORIGINAL (unmodified) line to IDE.
-			// The IDE must see real ACP messages.
-			fmt.Fprintln(os.Stdout, line)
-		}
-
-		if err := scanner.Err(); err != nil && err != io.EOF {
-			fmt.Fprintf(os.Stderr, "[recall/acp] downstream read error: %v\n", err)
+		defer close(relayed)
+		for msg := range proxyOut {
+			emit(msg)
 		}
 	}()
 
-	// Wait for both pipe goroutines to finish.
-	wg.Wait()
+	runErr := proxy.Run(ctx, proxyOut)
+	<-relayed
+	forward.Close()
 
 	// CRITICAL: Source owns the channel lifecycle. We must close it.
-	close(out)
-
-	// Wait for the agent process to exit and return its status.
-	return cmd.Wait()
-}
-
-// newScanner creates a bufio.Scanner with a generous buffer.
-//
-// ACP messages can be large — a single message may contain the full content
-// of a file the agent read. The default 64KB buffer is too small.
-// We allocate 4MB to handle even very large file reads without dropping data.
-func newScanner(r io.Reader) *bufio.Scanner {
-	scanner := bufio.NewScanner(r)
-	scanner.Buffer(make([]byte, 4*1024*1024), 4*1024*1024)
-	return scanner
+	closeOut()
+	return runErr
 }
@@ -0,0 +1,44 @@
+package acp
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/shshwtsuthar/recall/internal/prompt"
+	"github.com/shshwtsuthar/recall/source"
+)
+
+func init() {
+	source.Register(source.Descriptor{
+		Name:        "acp",
+		Description: "Proxy an ACP agent over stdio (Zed, JetBrains, Neovim)",
+		Flags: []source.Flag{
+			{Name: "agent", Usage: "agent binary to launch", Required: true},
+			{Name: "capture-llm", Usage: "also capture the agent's model API calls via a local HTTPS proxy (hosts: $RECALL_LLM_HOSTS)", Bool: true},
+			{Name: "strip-directives", Usage: "remove directive lines starting with this prefix (e.g. #recall) from prompts; set by $RECALL_STRIP_DIRECTIVES"},
+		},
+		PassThrough: true,
+		UsesStdio:   true,
+		New: func(opts source.Options) (source.Source, error) {
+			strip := opts.String("strip-directives")
+			if strip != "" {
+				if err := prompt.CheckPrefix(strip); err != nil {
+					return nil, fmt.Errorf("invalid --strip-directives %q: %w", strip, err)
+				}
+			}
+			var hosts []string
+			for _, h := range strings.Split(os.Getenv("RECALL_LLM_HOSTS"), ",") {
+				if h = strings.TrimSpace(h); h != "" {
+					hosts = append(hosts, h)
+				}
+			}
+			return New(Config{
+				AgentArgs:       append([]string{opts.String("agent")}, opts.Args...),
+				CaptureLLM:      opts.Bool("capture-llm"),
+				LLMHosts:        hosts,
+				StripDirectives: strip,
+			}), nil
+		},
+	})
+}
@@ -6,16 +6,30 @@ package acp
 
 import (
 	"encoding/json"
+	"sync"
+
+	"github.com/shshwtsuthar/recall/source/stdioproxy"
 )
 
 // rpcEnvelope is a minimal parse of a JSON-RPC 2.0 message.
 // We only decode the fields needed for session tracking.
 // The full raw line is never modified — we just peek at the structure.
 type rpcEnvelope struct {
+	ID     json.RawMessage `json:"id"`
 	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
 	Result json.RawMessage `json:"result"`
 }
 
+// sessionParams is the part of session/new and session/load requests we
+// care about: the working directory the IDE opened the session in.
+//
+//	{"jsonrpc":"2.0","id":2,"method":"session/new","params":{"cwd":"/home/me/project",...}}
+type sessionParams struct {
+	SessionID string `json:"sessionId"`
+	Cwd       string `json:"cwd"`
+}
+
 // sessionNewResult is the shape of the result from a session/new response.
 // ACP session/new responses look like:
 //
@@ -50,3 +64,55 @@ func extractSessionID(line string) string {
 
 	return result.SessionID
 }
+
+// sessionTracker follows session/new and session/load exchanges so each
+// session ID can be paired with the workspace (cwd) the IDE opened it in.
+//
+// The cwd only appears in the IDE's request and the session ID only in the
+// agent's response, so requests are remembered by JSON-RPC id until the
+// matching response arrives.
+type sessionTracker struct {
+	mu      sync.Mutex
+	pending map[string]string // JSON-RPC request id → cwd
+}
+
+func newSessionTracker() *sessionTracker {
+	return &sessionTracker{pending: make(map[string]string)}
+}
+
+// extract implements stdioproxy.Hooks.ExtractSession.
+func (t *sessionTracker) extract(direction, line string) (id, workspace string) {
+	var env rpcEnvelope
+	if err := json.Unmarshal([]byte(line), &env); err != nil {
+		return "", ""
+	}
+
+	if direction == stdioproxy.Upstream {
+		var params sessionParams
+		switch env.Method {
+		case "session/new":
+			if json.Unmarshal(env.Params, &params) == nil && env.ID != nil {
+				t.mu.Lock()
+				t.pending[string(env.ID)] = params.Cwd
+				t.mu.Unlock()
+			}
+		case "session/load":
+			// Loading names the session up front; no response needed.
+			if json.Unmarshal(env.Params, &params) == nil {
+				return params.SessionID, params.Cwd
+			}
+		}
+		return "", ""
+	}
+
+	// Only the agent answers session/new.
+	id = extractSessionID(line)
+	if id == "" {
+		return "", ""
+	}
+	t.mu.Lock()
+	workspace = t.pending[string(env.ID)]
+	delete(t.pending, string(env.ID))
+	t.mu.Unlock()
+	return id, workspace
+}
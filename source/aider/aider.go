@@ -0,0 +1,247 @@
+// Package aider implements the Source interface for aider
+// (https://aider.chat), which keeps per-repository transcripts on disk
+// instead of speaking a protocol recall could proxy.
+//
+// aider appends every session to <repo>/.aider.chat.history.md:
+//
+//	# aider chat started at 2024-05-01 10:00:00
+//
+//	#### the user's prompt (every line prefixed with "#### ")
+//
+//	> tool output such as "Applied edit to main.go" (prefixed with "> ")
+//
+//	The assistant's reply as plain markdown.
+//
+// and every prompt the user typed, with a timestamp, to
+// <repo>/.aider.input.history. This source tails both files, splits the chat
+// history into turns, and emits each turn as a message whose Raw is
+// {"role":"user|assistant|tool","content":"…"}. User turns are "upstream",
+// assistant turns "downstream" and tool output "log".
+//
+// Each "aider chat started" header begins a new session. User turns are
+// timestamped from the input history; assistant and tool turns inherit the
+// timestamp of the prompt they answer.
+package aider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shshwtsuthar/recall/internal/appdir"
+	"github.com/shshwtsuthar/recall/source"
+	"github.com/shshwtsuthar/recall/source/tail"
+)
+
+const (
+	chatHistoryFile  = ".aider.chat.history.md"
+	inputHistoryFile = ".aider.input.history"
+
+	// idleFlush is how long an open block must stop growing before it is
+	// emitted. aider writes each reply in one go, so a short quiet period
+	// means the turn is complete.
+	idleFlush = 3 * time.Second
+)
+
+// Config holds aider-specific configuration.
+type Config struct {
+	// Repos are the repositories whose aider transcripts are followed.
+	// Defaults to the current working directory.
+	Repos []string
+
+	// StateFile persists per-file read offsets and parser state.
+	// Defaults to aider-offsets.json in recall's data directory.
+	StateFile string
+
+	// PollInterval is how often transcripts are checked. Defaults to one second.
+	PollInterval time.Duration
+
+	// Backfill sends the full history of transcripts that already exist the
+	// first time recall runs. By default only new turns are sent.
+	Backfill bool
+}
+
+// Source implements the source.Source interface for aider transcripts.
+type Source struct {
+	config Config
+}
+
+// New creates an aider source with the given configuration.
+func New(config Config) *Source {
+	return &Source{config: config}
+}
+
+// Name returns the identifier for this source type.
+func (s *Source) Name() string {
+	return "aider"
+}
+
+// repoState is the live parsing state for one repository.
+type repoState struct {
+	chat     *chatParser
+	inputs   *inputHistory
+	lastLine time.Time
+}
+
+// Run tails the aider files of every configured repository until ctx is cancelled.
+func (s *Source) Run(ctx context.Context, out chan<- source.Message) error {
+	defer close(out)
+
+	repos, err := s.repos()
+	if err != nil {
+		return err
+	}
+	statePath := s.config.StateFile
+	if statePath == "" {
+		if statePath, err = appdir.Path("aider-offsets.json"); err != nil {
+			return err
+		}
+	}
+	offsets, err := tail.LoadOffsets(statePath)
+	if err != nil {
+		return err
+	}
+
+	states := make(map[string]*repoState, len(repos))
+	var files []string
+	for _, repo := range repos {
+		state := &repoState{inputs: newInputHistory()}
+		state.chat = &chatParser{repo: repo, inputTimes: state.inputs.lookup}
+		chatPath := filepath.Join(repo, chatHistoryFile)
+		if pos, ok := offsets.Get(chatPath); ok && pos.Meta != "" {
+			json.Unmarshal([]byte(pos.Meta), &state.chat.state)
+		} else if header := lastSessionHeader(chatPath); header != "" {
+			// Following an existing file from its end: we join the session
+			// that is already in progress.
+			state.chat.feed(header)
+		}
+		states[repo] = state
+
+		// Input history first: a prompt's timestamp must be known before the
+		// chat history line that echoes it is parsed in the same poll.
+		files = append(files, filepath.Join(repo, inputHistoryFile), chatPath)
+		fmt.Fprintf(os.Stderr, "[recall/aider] watching %s\n", repo)
+	}
+
+	send := func(repo string, turns []turn) bool {
+		for _, t := range turns {
+			select {
+			case out <- s.message(repo, t):
+			case <-ctx.Done():
+				return false
+			}
+		}
+		return true
+	}
+	persist := func(repo string) {
+		meta, _ := json.Marshal(states[repo].chat.state)
+		offsets.SetMeta(filepath.Join(repo, chatHistoryFile), string(meta))
+	}
+
+	tailer := tail.New(tail.Config{
+		Name: s.Name(),
+		Files: func() ([]string, error) {
+			return files, nil
+		},
+		Interval: s.config.PollInterval,
+		Offsets:  offsets,
+		Backfill: s.config.Backfill,
+		OnReset: func(path string) {
+			state := states[filepath.Dir(path)]
+			if filepath.Base(path) == chatHistoryFile {
+				state.chat.state = chatState{}
+			} else {
+				state.inputs = newInputHistory()
+				state.chat.inputTimes = state.inputs.lookup
+			}
+		},
+		AfterPoll: func() {
+			for repo, state := range states {
+				if state.chat.pending() && time.Since(state.lastLine) >= idleFlush {
+					if send(repo, state.chat.flush()) {
+						persist(repo)
+					}
+				}
+			}
+		},
+	})
+
+	return tailer.Run(ctx, func(line tail.Line) bool {
+		repo := filepath.Dir(line.Path)
+		state := states[repo]
+		if filepath.Base(line.Path) == inputHistoryFile {
+			state.inputs.feed(line.Text)
+			return true
+		}
+
+		state.lastLine = time.Now()
+		if !send(repo, state.chat.feed(line.Text)) {
+			return false
+		}
+		persist(repo)
+		return true
+	})
+}
+
+// message converts a turn in repo to a source.Message.
+func (s *Source) message(repo string, t turn) source.Message {
+	direction := "log"
+	switch t.Kind {
+	case kindUser:
+		direction = "upstream"
+	case kindAssistant:
+		direction = "downstream"
+	}
+	raw, _ := json.Marshal(struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}{t.Kind, t.Content})
+
+	return source.Message{
+		Raw:        string(raw),
+		Direction:  direction,
+		SessionID:  t.SessionID,
+		Workspace:  repo,
+		SourceName: s.Name(),
+		CapturedAt: t.At,
+	}
+}
+
+// repos resolves the configured repositories to absolute paths.
+func (s *Source) repos() ([]string, error) {
+	repos := s.config.Repos
+	if len(repos) == 0 {
+		repos = []string{"."}
+	}
+	abs := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		path, err := filepath.Abs(repo)
+		if err != nil {
+			return nil, fmt.Errorf("resolve repo %q: %w", repo, err)
+		}
+		abs = append(abs, path)
+	}
+	return abs, nil
+}
+
+// lastSessionHeader returns the last "aider chat started" line in path, or
+// "" if the file does not exist or has none.
+func lastSessionHeader(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	i := strings.LastIndex(string(data), "\n"+sessionHeaderPrefix)
+	if i < 0 {
+		if !strings.HasPrefix(string(data), sessionHeaderPrefix) {
+			return ""
+		}
+		i = -1
+	}
+	line, _, _ := strings.Cut(string(data[i+1:]), "\n")
+	return line
+}
@@ -0,0 +1,238 @@
+package aider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// Markers aider writes into .aider.chat.history.md.
+const (
+	sessionHeaderPrefix = "# aider chat started at "
+	userPrefix          = "####"
+	toolPrefix          = ">"
+
+	// headerTimeLayout is the format of the session header timestamp.
+	headerTimeLayout = "2006-01-02 15:04:05"
+
+	// inputTimeLayout is the format of .aider.input.history entry headers.
+	inputTimeLayout = "2006-01-02 15:04:05.999999"
+)
+
+// Turn kinds, also used as the "role" of emitted turns.
+const (
+	kindUser      = "user"
+	kindAssistant = "assistant"
+	kindTool      = "tool"
+)
+
+// turn is one complete user, assistant or tool-output block.
+type turn struct {
+	Kind      string
+	Content   string
+	SessionID string
+	At        time.Time
+}
+
+// chatState is the parser's position inside a chat history file. It is
+// persisted (as tail Position meta) so a restart resumes mid-session with the
+// same session ID and without losing a partially read block.
+type chatState struct {
+	SessionID    string    `json:"session_id,omitempty"`
+	SessionStart time.Time `json:"session_start,omitempty"`
+	LastUserAt   time.Time `json:"last_user_at,omitempty"`
+	Kind         string    `json:"kind,omitempty"`
+	Lines        []string  `json:"lines,omitempty"`
+}
+
+// chatParser turns chat history lines into turns.
+type chatParser struct {
+	repo  string
+	state chatState
+
+	// inputTimes resolves a user turn's text to when it was typed.
+	inputTimes func(text string) (time.Time, bool)
+}
+
+// feed consumes one line and returns any turns it completes.
+func (p *chatParser) feed(line string) []turn {
+	if strings.HasPrefix(line, sessionHeaderPrefix) {
+		done := p.flush()
+		started, err := time.ParseInLocation(headerTimeLayout, strings.TrimSpace(strings.TrimPrefix(line, sessionHeaderPrefix)), time.Local)
+		if err != nil {
+			started = time.Now()
+		}
+		p.state = chatState{
+			SessionID:    sessionID(p.repo, line),
+			SessionStart: started.UTC(),
+		}
+		return done
+	}
+
+	kind, text := classify(line)
+	if kind == "" {
+		// Blank line: belongs to whatever block is open (assistant replies
+		// contain paragraphs), but never opens a block by itself.
+		if p.state.Kind != "" {
+			p.state.Lines = append(p.state.Lines, "")
+		}
+		return nil
+	}
+
+	var done []turn
+	if kind != p.state.Kind {
+		done = p.flush()
+		p.state.Kind = kind
+	}
+	p.state.Lines = append(p.state.Lines, text)
+	return done
+}
+
+// flush completes the open block, if any.
+func (p *chatParser) flush() []turn {
+	kind, lines := p.state.Kind, p.state.Lines
+	p.state.Kind, p.state.Lines = "", nil
+
+	content := strings.TrimSpace(strings.Join(lines, "\n"))
+	if kind == "" || content == "" {
+		return nil
+	}
+
+	t := turn{Kind: kind, Content: content, SessionID: p.state.SessionID}
+	switch {
+	case kind == kindUser:
+		if at, ok := p.inputTimes(content); ok {
+			t.At = at
+		} else {
+			t.At = time.Now().UTC()
+		}
+		p.state.LastUserAt = t.At
+	case !p.state.LastUserAt.IsZero():
+		// Assistant replies and tool output carry no timestamp of their own;
+		// they belong to the prompt that produced them.
+		t.At = p.state.LastUserAt
+	case !p.state.SessionStart.IsZero():
+		t.At = p.state.SessionStart
+	default:
+		t.At = time.Now().UTC()
+	}
+	return []turn{t}
+}
+
+// pending reports whether a block is open.
+func (p *chatParser) pending() bool {
+	return p.state.Kind != ""
+}
+
+// classify determines which block a non-header line belongs to.
+func classify(line string) (kind, text string) {
+	switch {
+	case strings.TrimSpace(line) == "":
+		return "", ""
+	case strings.HasPrefix(line, userPrefix):
+		return kindUser, strings.TrimPrefix(strings.TrimPrefix(line, userPrefix), " ")
+	case strings.HasPrefix(line, toolPrefix):
+		return kindTool, strings.TrimPrefix(strings.TrimPrefix(line, toolPrefix), " ")
+	default:
+		return kindAssistant, line
+	}
+}
+
+// sessionID derives a stable session ID from the repository and the session
+// header line, so re-reading a file always yields the same IDs.
+func sessionID(repo, header string) string {
+	sum := sha256.Sum256([]byte(repo + "\x00" + header))
+	return "aider-" + hex.EncodeToString(sum[:8])
+}
+
+// inputHistory collects timestamps from .aider.input.history, which
+// prompt_toolkit writes as:
+//
+//	# 2024-05-01 10:00:01.123456
+//	+first line of input
+//	+second line
+type inputHistory struct {
+	at       time.Time
+	lines    []string
+	recorded string // text the current entry is recorded under
+	entries  map[string][]time.Time
+}
+
+func newInputHistory() *inputHistory {
+	return &inputHistory{entries: make(map[string][]time.Time)}
+}
+
+// feed consumes one input history line.
+func (h *inputHistory) feed(line string) {
+	switch {
+	case strings.HasPrefix(line, "# "):
+		h.finish()
+		if at, err := time.ParseInLocation(inputTimeLayout, strings.TrimPrefix(line, "# "), time.Local); err == nil {
+			h.at = at.UTC()
+		}
+	case strings.HasPrefix(line, "+"):
+		h.lines = append(h.lines, strings.TrimPrefix(line, "+"))
+		// Entries have no terminator, so record the text as it grows, each
+		// version replacing the one before.
+		h.record()
+	}
+}
+
+func (h *inputHistory) finish() {
+	h.record()
+	h.at, h.lines, h.recorded = time.Time{}, nil, ""
+}
+
+func (h *inputHistory) record() {
+	if h.at.IsZero() || len(h.lines) == 0 {
+		return
+	}
+	text := strings.TrimSpace(strings.Join(h.lines, "\n"))
+	if text == h.recorded {
+		return
+	}
+	previous := h.recorded
+	h.recorded = text
+	// A shorter version already matched by a chat entry has done its job;
+	// recording the longer one would leave it to match nothing, or a later
+	// prompt that happens to have the same text.
+	if previous != "" && !h.remove(previous, h.at) {
+		return
+	}
+	times := h.entries[text]
+	if len(times) == 0 || !times[len(times)-1].Equal(h.at) {
+		h.entries[text] = append(times, h.at)
+	}
+}
+
+// remove deletes the time at recorded for text, reporting whether it was
+// there.
+func (h *inputHistory) remove(text string, at time.Time) bool {
+	times := h.entries[text]
+	for i := len(times) - 1; i >= 0; i-- {
+		if times[i].Equal(at) {
+			if len(times) == 1 {
+				delete(h.entries, text)
+			} else {
+				h.entries[text] = append(times[:i:i], times[i+1:]...)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns (and consumes) the earliest recorded time for text.
+func (h *inputHistory) lookup(text string) (time.Time, bool) {
+	times := h.entries[text]
+	if len(times) == 0 {
+		return time.Time{}, false
+	}
+	if len(times) == 1 {
+		delete(h.entries, text)
+	} else {
+		h.entries[text] = times[1:]
+	}
+	return times[0], true
+}
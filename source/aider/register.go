@@ -0,0 +1,20 @@
+package aider
+
+import "github.com/shshwtsuthar/recall/source"
+
+func init() {
+	source.Register(source.Descriptor{
+		Name:        "aider",
+		Description: "Tail aider chat and input history files",
+		Flags: []source.Flag{
+			{Name: "repo", Usage: "repository to follow (repeatable; default: current directory)", Repeated: true},
+			{Name: "backfill", Usage: "on first run, send existing history from the start", Bool: true},
+		},
+		New: func(opts source.Options) (source.Source, error) {
+			return New(Config{
+				Repos:    opts.Strings("repo"),
+				Backfill: opts.Bool("backfill"),
+			}), nil
+		},
+	})
+}
@@ -0,0 +1,191 @@
+// Package claudecli implements the Source interface for Claude Code CLI
+// session transcripts.
+//
+// Claude Code writes one JSONL file per session under
+// ~/.claude/projects/<encoded-project-path>/<session-id>.jsonl, appending a
+// record for every user turn, assistant turn and tool result. This source
+// tails those files as they grow and emits each record as a "log" message.
+// There is no subprocess and nothing to forward: the CLI is unaware of us.
+package claudecli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shshwtsuthar/recall/internal/appdir"
+	"github.com/shshwtsuthar/recall/source"
+	"github.com/shshwtsuthar/recall/source/tail"
+)
+
+// Config holds Claude Code CLI-specific configuration.
+type Config struct {
+	// LogDir is the Claude Code projects directory.
+	// Defaults to $CLAUDE_CONFIG_DIR/projects, or ~/.claude/projects.
+	LogDir string
+
+	// StateFile persists per-file read offsets across restarts.
+	// Defaults to claude-cli-offsets.json in recall's data directory.
+	StateFile string
+
+	// PollInterval is how often transcripts are checked for new lines.
+	// Defaults to one second.
+	PollInterval time.Duration
+
+	// Backfill sends the full history of transcripts that already exist the
+	// first time recall runs. By default only new lines are sent.
+	Backfill bool
+}
+
+// Source implements the source.Source interface for Claude Code transcripts.
+type Source struct {
+	config Config
+}
+
+// New creates a Claude Code CLI source with the given configuration.
+func New(config Config) *Source {
+	return &Source{config: config}
+}
+
+// Name returns the identifier for this source type.
+func (s *Source) Name() string {
+	return "claude-cli"
+}
+
+// Run tails every transcript under LogDir until ctx is cancelled.
+//
+// Each line becomes one Message with Direction "log". The session ID is the
+// transcript's file name, which Claude Code sets to the session UUID, so
+// resumed sessions (which append to the same file) stay one trajectory.
+func (s *Source) Run(ctx context.Context, out chan<- source.Message) error {
+	defer close(out)
+
+	logDir, err := s.logDir()
+	if err != nil {
+		return err
+	}
+	statePath := s.config.StateFile
+	if statePath == "" {
+		if statePath, err = appdir.Path("claude-cli-offsets.json"); err != nil {
+			return err
+		}
+	}
+	offsets, err := tail.LoadOffsets(statePath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "[recall/claude-cli] watching %s\n", logDir)
+
+	// Transcript entries carry the session's working directory; remember the
+	// latest one per file so entries without it still get a workspace.
+	workspaces := make(map[string]string) // "" once looked for in vain
+	forget := func(path string) { delete(workspaces, path) }
+
+	tailer := tail.New(tail.Config{
+		Name: s.Name(),
+		Files: func() ([]string, error) {
+			// Glob returns paths in lexical order, which keeps polls deterministic.
+			return filepath.Glob(filepath.Join(logDir, "*", "*.jsonl"))
+		},
+		Interval: s.config.PollInterval,
+		Offsets:  offsets,
+		Backfill: s.config.Backfill,
+		OnReset:  forget,
+		OnRemove: forget,
+	})
+
+	return tailer.Run(ctx, func(line tail.Line) bool {
+		if strings.TrimSpace(line.Text) == "" {
+			return true
+		}
+		var entry struct {
+			Cwd string `json:"cwd"`
+		}
+		if json.Unmarshal([]byte(line.Text), &entry) == nil && entry.Cwd != "" {
+			workspaces[line.Path] = entry.Cwd
+		} else if _, ok := workspaces[line.Path]; !ok {
+			// The first lines (summaries, the first prompt) come before any
+			// cwd; without a workspace they would slip past the opt-out.
+			workspaces[line.Path] = findWorkspace(line.Path)
+		}
+		msg := source.Message{
+			Raw:        line.Text,
+			Direction:  "log",
+			SessionID:  sessionID(line.Path),
+			Workspace:  workspaces[line.Path],
+			SourceName: s.Name(),
+			CapturedAt: time.Now().UTC(),
+		}
+		select {
+		case out <- msg:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
+
+// logDir resolves the configured or default transcript directory.
+func (s *Source) logDir() (string, error) {
+	if s.config.LogDir != "" {
+		return s.config.LogDir, nil
+	}
+	if dir := os.Getenv("CLAUDE_CONFIG_DIR"); dir != "" {
+		return filepath.Join(dir, "projects"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve home dir: %w", err)
+	}
+	return filepath.Join(home, ".claude", "projects"), nil
+}
+
+// findWorkspace returns the first cwd recorded in a transcript or, if it has
+// none yet, the project directory the transcript is filed under, if that
+// decodes to an existing directory.
+func findWorkspace(path string) string {
+	if f, err := os.Open(path); err == nil {
+		defer f.Close()
+		r := bufio.NewReader(f)
+		for {
+			line, err := r.ReadBytes('\n')
+			if bytes.Contains(line, []byte(`"cwd"`)) {
+				var entry struct {
+					Cwd string `json:"cwd"`
+				}
+				if json.Unmarshal(line, &entry) == nil && entry.Cwd != "" {
+					return entry.Cwd
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	// Claude Code names the directory after the project path with every
+	// separator turned into "-", which can't be undone for paths that
+	// contain "-" themselves; only trust a decoding that exists.
+	project := filepath.Base(filepath.Dir(path))
+	if !strings.HasPrefix(project, "-") {
+		return ""
+	}
+	dir := filepath.FromSlash(strings.ReplaceAll(project, "-", "/"))
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return dir
+	}
+	return ""
+}
+
+// sessionID derives the session identifier from a transcript path:
+// ~/.claude/projects/-home-me-proj/3f2a….jsonl → "3f2a…".
+func sessionID(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
@@ -0,0 +1,20 @@
+package claudecli
+
+import "github.com/shshwtsuthar/recall/source"
+
+func init() {
+	source.Register(source.Descriptor{
+		Name:        "claude-cli",
+		Description: "Tail Claude Code CLI session transcripts",
+		Flags: []source.Flag{
+			{Name: "log-dir", Usage: "Claude Code projects directory (default $CLAUDE_CONFIG_DIR/projects or ~/.claude/projects)"},
+			{Name: "backfill", Usage: "on first run, send existing transcripts from the start", Bool: true},
+		},
+		New: func(opts source.Options) (source.Source, error) {
+			return New(Config{
+				LogDir:   opts.String("log-dir"),
+				Backfill: opts.Bool("backfill"),
+			}), nil
+		},
+	})
+}
@@ -0,0 +1,239 @@
+// Package execplugin implements the Source interface for external helper
+// programs, so teams can integrate agents recall has no built-in source for
+// in any language, while reusing recall's scrubbing and transmission.
+//
+// recall launches the helper with RECALL_PLUGIN_PROTOCOL set to the protocol
+// version it speaks and reads newline-delimited JSON from the helper's
+// stdout. The helper's stderr is passed through; its stdin is empty.
+//
+// Protocol version 1:
+//
+// The first line must be a handshake:
+//
+//	{"type":"hello","protocol":1,"name":"my-agent"}
+//
+// protocol must equal the version recall offered; name is optional and is
+// reported in recall's logs. Every following line is one of:
+//
+//	{"type":"message","direction":"upstream","raw":"…","session_id":"…","captured_at":"2024-05-01T10:00:00Z"}
+//	{"type":"log","message":"text for recall's stderr"}
+//
+// A message maps one-to-one onto source.Message: raw is required and
+// unscrubbed, direction is one of "upstream", "downstream" or "log",
+// session_id is optional, and captured_at (RFC 3339) defaults to the time
+// recall read the line. Lines with an unknown type are ignored, so helpers
+// may send newer line types to older recall versions; malformed lines are
+// reported and skipped. A line longer than 4 MiB is an error that stops the
+// helper. When the helper exits, the source stops.
+package execplugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/shshwtsuthar/recall/source"
+)
+
+// ProtocolVersion is the plugin protocol version this source speaks.
+const ProtocolVersion = 1
+
+// handshakeTimeout bounds how long a helper may take to say hello.
+const handshakeTimeout = 10 * time.Second
+
+// maxLineSize bounds one line from the helper.
+const maxLineSize = 4 * 1024 * 1024
+
+// Config holds exec plugin-specific configuration.
+type Config struct {
+	// Command is the helper binary and its arguments.
+	Command []string
+}
+
+// Source implements the source.Source interface for exec plugins.
+type Source struct {
+	config Config
+}
+
+// New creates an exec plugin source with the given configuration.
+func New(config Config) *Source {
+	return &Source{config: config}
+}
+
+// Name returns the identifier for this source type.
+func (s *Source) Name() string {
+	return "exec"
+}
+
+// line is any line written by the helper.
+type line struct {
+	Type string `json:"type"`
+
+	// hello
+	Protocol int    `json:"protocol"`
+	Name     string `json:"name"`
+
+	// message
+	Direction  string  `json:"direction"`
+	Raw        *string `json:"raw"`
+	SessionID  string  `json:"session_id"`
+	CapturedAt string  `json:"captured_at"`
+
+	// log
+	Message string `json:"message"`
+}
+
+// Run launches the helper and relays its messages until it exits or ctx is
+// cancelled.
+func (s *Source) Run(ctx context.Context, out chan<- source.Message) error {
+	defer close(out)
+
+	if len(s.config.Command) == 0 {
+		return fmt.Errorf("no plugin command specified")
+	}
+
+	cmd := exec.CommandContext(ctx, s.config.Command[0], s.config.Command[1:]...)
+	cmd.Env = append(os.Environ(), "RECALL_PLUGIN_PROTOCOL="+strconv.Itoa(ProtocolVersion))
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("create plugin stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start plugin %q: %w", s.config.Command[0], err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	name, err := s.handshake(scanner, cmd)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	prefix := fmt.Sprintf("[recall/exec:%s]", name)
+	fmt.Fprintf(os.Stderr, "%s plugin ready (protocol %d)\n", prefix, ProtocolVersion)
+
+	n := 1
+	for scanner.Scan() {
+		n++
+		var l line
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			fmt.Fprintf(os.Stderr, "%s line %d: invalid JSON: %v\n", prefix, n, err)
+			continue
+		}
+
+		switch l.Type {
+		case "message":
+			msg, err := s.toMessage(l)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s line %d: %v\n", prefix, n, err)
+				continue
+			}
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+				cmd.Wait()
+				return nil
+			}
+		case "log":
+			fmt.Fprintf(os.Stderr, "%s %s\n", prefix, l.Message)
+		default:
+			// Newer helpers may send line types we don't know yet.
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			err = fmt.Errorf("line %d is longer than %d bytes", n+1, maxLineSize)
+		}
+		// Nothing reads the helper's stdout any more, so a helper still
+		// writing to it would never exit.
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("plugin %s: %w", name, err)
+	}
+
+	if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("plugin exited: %w", err)
+	}
+	return nil
+}
+
+// handshake reads the hello line, returning the helper's name.
+func (s *Source) handshake(scanner *bufio.Scanner, cmd *exec.Cmd) (string, error) {
+	type result struct {
+		hello line
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		if !scanner.Scan() {
+			err := scanner.Err()
+			if err == nil {
+				err = fmt.Errorf("plugin closed stdout")
+			}
+			done <- result{err: err}
+			return
+		}
+		var hello line
+		err := json.Unmarshal(scanner.Bytes(), &hello)
+		done <- result{hello, err}
+	}()
+
+	var r result
+	select {
+	case r = <-done:
+	case <-time.After(handshakeTimeout):
+		return "", fmt.Errorf("plugin %q sent no hello within %s", cmd.Path, handshakeTimeout)
+	}
+	switch {
+	case r.err != nil:
+		return "", fmt.Errorf("plugin handshake: %w", r.err)
+	case r.hello.Type != "hello":
+		return "", fmt.Errorf("plugin handshake: first line must have type \"hello\", got %q", r.hello.Type)
+	case r.hello.Protocol != ProtocolVersion:
+		return "", fmt.Errorf("plugin speaks protocol %d, recall speaks %d", r.hello.Protocol, ProtocolVersion)
+	}
+
+	name := r.hello.Name
+	if name == "" {
+		name = cmd.Path
+	}
+	return name, nil
+}
+
+// toMessage validates a message line and converts it.
+func (s *Source) toMessage(l line) (source.Message, error) {
+	if l.Raw == nil {
+		return source.Message{}, fmt.Errorf("message requires raw")
+	}
+	switch l.Direction {
+	case "upstream", "downstream", "log":
+	default:
+		return source.Message{}, fmt.Errorf("invalid direction %q", l.Direction)
+	}
+
+	capturedAt := time.Now().UTC()
+	if l.CapturedAt != "" {
+		t, err := time.Parse(time.RFC3339Nano, l.CapturedAt)
+		if err != nil {
+			return source.Message{}, fmt.Errorf("invalid captured_at: %w", err)
+		}
+		capturedAt = t.UTC()
+	}
+
+	return source.Message{
+		Raw:        *l.Raw,
+		Direction:  l.Direction,
+		SessionID:  l.SessionID,
+		SourceName: s.Name(),
+		CapturedAt: capturedAt,
+	}, nil
+}
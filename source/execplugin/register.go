@@ -0,0 +1,17 @@
+package execplugin
+
+import "github.com/shshwtsuthar/recall/source"
+
+func init() {
+	source.Register(source.Descriptor{
+		Name:        "exec",
+		Description: "Read NDJSON messages from an external helper program",
+		Flags: []source.Flag{
+			{Name: "command", Usage: "helper binary to launch", Required: true},
+		},
+		PassThrough: true,
+		New: func(opts source.Options) (source.Source, error) {
+			return New(Config{Command: append([]string{opts.String("command")}, opts.Args...)}), nil
+		},
+	})
+}
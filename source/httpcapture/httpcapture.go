@@ -0,0 +1,342 @@
+// Package httpcapture is a reverse proxy that records every HTTP exchange it
+// forwards as a pair of source.Message values.
+//
+// It is shared by the sources that sit on an agent's LLM API traffic: the
+// http-llm reverse proxy and the ACP source's TLS-intercepting forward proxy.
+// Requests and responses are forwarded untouched, including streaming (SSE)
+// responses, which are flushed chunk by chunk as they arrive.
+//
+// Each exchange is emitted as a request message with the request body and a
+// response message with the full response body once it has finished
+// streaming, linked by an exchange ID. HTTP headers are never captured —
+// they carry API keys.
+//
+// Compressed bodies are captured decompressed. The one change made to
+// forwarded traffic is that the request's Accept-Encoding is narrowed to
+// the codings recall can decode (gzip and deflate), so agents that also
+// accept br or zstd don't get responses recall can't read.
+package httpcapture
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shshwtsuthar/recall/source"
+)
+
+// maxCaptureSize caps how much of one body is kept for the trajectory.
+// Forwarding is never capped; only the captured copy is truncated.
+const maxCaptureSize = 16 * 1024 * 1024
+
+// Capture describes how exchanges are forwarded and recorded.
+type Capture struct {
+	// SourceName is stamped on every emitted message.
+	SourceName string
+
+	// RequestDirection and ResponseDirection label the two messages of an
+	// exchange, e.g. "upstream"/"downstream".
+	RequestDirection  string
+	ResponseDirection string
+
+	// Host, if set, is recorded on exchanges instead of the inbound
+	// request's Host (which for a reverse proxy is recall's own address).
+	Host string
+
+	// Rewrite points the outbound request at the real server.
+	Rewrite func(*httputil.ProxyRequest)
+
+	// SessionID assigns the exchange to a session. It receives the inbound
+	// request, its body and the exchange ID.
+	SessionID func(r *http.Request, body []byte, exchangeID string) string
+
+	// Emit receives every captured message. It may block.
+	Emit func(source.Message)
+
+	// Transport overrides the transport used to reach the real server.
+	Transport http.RoundTripper
+}
+
+// Handler returns an http.Handler that forwards and captures exchanges.
+func (c *Capture) Handler() http.Handler {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			c.Rewrite(pr)
+			limitEncodings(pr.Out.Header)
+		},
+		// Flush immediately so streamed tokens reach the agent without delay.
+		FlushInterval: -1,
+		Transport:     c.Transport,
+		ModifyResponse: func(resp *http.Response) error {
+			ex := exchangeFrom(resp.Request.Context())
+			resp.Body = &captureBody{
+				ReadCloser: resp.Body,
+				onClose: func(body []byte, truncated bool) {
+					body, encoding, cut := decode(resp.Header.Get("Content-Encoding"), body)
+					c.Emit(c.message(ex, c.ResponseDirection, responseEnvelope{
+						ExchangeID:      ex.id,
+						Host:            ex.host,
+						Status:          resp.StatusCode,
+						ContentType:     resp.Header.Get("Content-Type"),
+						ContentEncoding: encoding,
+						Body:            jsonOrString(body),
+						Truncated:       truncated || cut,
+					}))
+				},
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			ex := exchangeFrom(r.Context())
+			fmt.Fprintf(os.Stderr, "[recall/%s] upstream error: %v\n", c.SourceName, err)
+			c.Emit(c.message(ex, c.ResponseDirection, responseEnvelope{
+				ExchangeID: ex.id,
+				Host:       ex.host,
+				Status:     http.StatusBadGateway,
+				Error:      err.Error(),
+			}))
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body.Close()
+		// Restore the body so the proxy forwards exactly what the agent sent.
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+
+		ex := exchange{id: randomHex(8), host: r.Host}
+		if c.Host != "" {
+			ex.host = c.Host
+		}
+		decoded, encoding, cut := decode(r.Header.Get("Content-Encoding"), body)
+		ex.sessionID = c.SessionID(r, decoded, ex.id)
+		r = r.WithContext(context.WithValue(r.Context(), exchangeKey{}, ex))
+
+		captured, truncated := capCapture(decoded)
+		c.Emit(c.message(ex, c.RequestDirection, requestEnvelope{
+			ExchangeID:      ex.id,
+			Host:            ex.host,
+			Method:          r.Method,
+			Path:            r.URL.RequestURI(),
+			ContentEncoding: encoding,
+			Body:            jsonOrString(captured),
+			Truncated:       truncated || cut,
+		}))
+
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// exchange identifies one request/response pair.
+type exchange struct {
+	id        string
+	host      string
+	sessionID string
+}
+
+type exchangeKey struct{}
+
+func exchangeFrom(ctx context.Context) exchange {
+	ex, _ := ctx.Value(exchangeKey{}).(exchange)
+	return ex
+}
+
+// requestEnvelope is the Raw content of a request message. ContentEncoding
+// is set only when Body is still encoded, in a coding recall can't decode.
+type requestEnvelope struct {
+	ExchangeID      string          `json:"exchange_id"`
+	Host            string          `json:"host,omitempty"`
+	Method          string          `json:"method"`
+	Path            string          `json:"path"`
+	ContentEncoding string          `json:"content_encoding,omitempty"`
+	Body            json.RawMessage `json:"body,omitempty"`
+	Truncated       bool            `json:"truncated,omitempty"`
+}
+
+// responseEnvelope is the Raw content of a response message.
+// For streaming responses Body is the raw SSE text.
+type responseEnvelope struct {
+	ExchangeID      string          `json:"exchange_id"`
+	Host            string          `json:"host,omitempty"`
+	Status          int             `json:"status"`
+	ContentType     string          `json:"content_type,omitempty"`
+	ContentEncoding string          `json:"content_encoding,omitempty"`
+	Body            json.RawMessage `json:"body,omitempty"`
+	Truncated       bool            `json:"truncated,omitempty"`
+	Error           string          `json:"error,omitempty"`
+}
+
+func (c *Capture) message(ex exchange, direction string, envelope any) source.Message {
+	raw, _ := json.Marshal(envelope)
+	return source.Message{
+		Raw:        string(raw),
+		Direction:  direction,
+		SessionID:  ex.sessionID,
+		SourceName: c.SourceName,
+		CapturedAt: time.Now().UTC(),
+	}
+}
+
+// captureBody tees a response body into memory and reports it on Close.
+type captureBody struct {
+	io.ReadCloser
+	buf       []byte
+	truncated bool
+	onClose   func(body []byte, truncated bool)
+	once      sync.Once
+}
+
+func (c *captureBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		if room := maxCaptureSize - len(c.buf); room >= n {
+			c.buf = append(c.buf, p[:n]...)
+		} else {
+			c.buf = append(c.buf, p[:max(room, 0)]...)
+			c.truncated = true
+		}
+	}
+	return n, err
+}
+
+func (c *captureBody) Close() error {
+	err := c.ReadCloser.Close()
+	c.once.Do(func() { c.onClose(c.buf, c.truncated) })
+	return err
+}
+
+func capCapture(body []byte) ([]byte, bool) {
+	if len(body) > maxCaptureSize {
+		return body[:maxCaptureSize], true
+	}
+	return body, false
+}
+
+// limitEncodings narrows a request's Accept-Encoding to the codings decode
+// handles, dropping the header if none is left.
+func limitEncodings(h http.Header) {
+	accept := h.Get("Accept-Encoding")
+	if accept == "" {
+		return
+	}
+	var kept []string
+	for _, coding := range strings.Split(accept, ",") {
+		name, _, _ := strings.Cut(coding, ";")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "gzip", "x-gzip", "deflate", "identity":
+			kept = append(kept, strings.TrimSpace(coding))
+		}
+	}
+	if len(kept) == 0 {
+		h.Del("Accept-Encoding")
+		return
+	}
+	h.Set("Accept-Encoding", strings.Join(kept, ", "))
+}
+
+// decode undoes a gzip or deflate Content-Encoding, keeping at most
+// maxCaptureSize bytes. A body that was cut short is decoded as far as it
+// goes. Bodies in other codings, or that don't decode at all, are returned
+// as they are, with their coding.
+func decode(contentEncoding string, body []byte) (decoded []byte, encoding string, truncated bool) {
+	var (
+		r   io.Reader
+		err error
+	)
+	switch encoding = strings.ToLower(strings.TrimSpace(contentEncoding)); encoding {
+	case "", "identity":
+		return body, "", false
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// Properly zlib-wrapped, though some servers send raw deflate.
+		if r, err = zlib.NewReader(bytes.NewReader(body)); err != nil {
+			r, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	default:
+		return body, encoding, false
+	}
+	if err != nil {
+		return body, encoding, false
+	}
+	decoded, err = io.ReadAll(io.LimitReader(r, maxCaptureSize+1))
+	if len(decoded) == 0 && err != nil {
+		return body, encoding, false
+	}
+	if len(decoded) > maxCaptureSize {
+		return decoded[:maxCaptureSize], "", true
+	}
+	return decoded, "", false
+}
+
+// jsonOrString embeds valid JSON as-is and anything else (SSE text,
+// truncated JSON) as a JSON string.
+func jsonOrString(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return body
+	}
+	quoted, _ := json.Marshal(string(body))
+	return quoted
+}
+
+// conversationStart is the subset of a chat request that identifies which
+// conversation it belongs to. Both OpenAI and Anthropic resend the full
+// history on every turn, so the system prompt plus the first user message
+// are stable across all turns of one conversation.
+type conversationStart struct {
+	System   json.RawMessage `json:"system"`
+	Messages []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+}
+
+// DeriveSessionID returns a stable ID for the conversation a chat request
+// belongs to, or "" for requests that carry no conversation history.
+func DeriveSessionID(body []byte) string {
+	var start conversationStart
+	if err := json.Unmarshal(body, &start); err != nil {
+		return ""
+	}
+
+	h := sha256.New()
+	h.Write(start.System)
+	for _, m := range start.Messages {
+		switch m.Role {
+		case "system", "developer":
+			h.Write(m.Content)
+		case "user":
+			h.Write(m.Content)
+			return "llm-" + hex.EncodeToString(h.Sum(nil)[:8])
+		}
+	}
+	return ""
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
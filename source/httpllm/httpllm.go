@@ -0,0 +1,154 @@
+// Package httpllm implements the Source interface for agents that call an
+// OpenAI- or Anthropic-compatible HTTP API directly instead of speaking ACP.
+//
+// The agent is pointed at recall (e.g. OPENAI_BASE_URL=http://127.0.0.1:4141/v1)
+// and recall reverse-proxies every request to the real upstream base URL.
+// Forwarding and capture are handled by package httpcapture: each
+// request/response pair is emitted as an "upstream" message with the request
+// body and a "downstream" message with the full response body, sharing an
+// exchange ID.
+package httpllm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/shshwtsuthar/recall/source"
+	"github.com/shshwtsuthar/recall/source/httpcapture"
+)
+
+const (
+	// DefaultListen is the address the proxy binds when Config.Listen is empty.
+	DefaultListen = "127.0.0.1:4141"
+
+	// DefaultConversationHeader lets clients group exchanges explicitly.
+	DefaultConversationHeader = "X-Recall-Conversation"
+)
+
+// Config holds HTTP LLM proxy-specific configuration.
+type Config struct {
+	// Upstream is the real API base URL, e.g. "https://api.anthropic.com".
+	// Request paths are appended to it.
+	Upstream string
+
+	// Listen is the local address to accept agent requests on.
+	Listen string
+
+	// ConversationHeader names the request header whose value is used as the
+	// session ID. When absent, the session ID is derived from the request body.
+	ConversationHeader string
+}
+
+// Source implements the source.Source interface for HTTP LLM APIs.
+type Source struct {
+	config Config
+
+	// inflight tracks handler invocations so Run never closes the output
+	// channel while a response is still being captured.
+	inflight sync.WaitGroup
+}
+
+// New creates an HTTP LLM proxy source with the given configuration.
+func New(config Config) *Source {
+	if config.Listen == "" {
+		config.Listen = DefaultListen
+	}
+	if config.ConversationHeader == "" {
+		config.ConversationHeader = DefaultConversationHeader
+	}
+	return &Source{config: config}
+}
+
+// Name returns the identifier for this source type.
+func (s *Source) Name() string {
+	return "http-llm"
+}
+
+// Run listens on Config.Listen and proxies to Config.Upstream until ctx is cancelled.
+func (s *Source) Run(ctx context.Context, out chan<- source.Message) error {
+	handler, err := s.Handler(ctx, out)
+	if err != nil {
+		close(out)
+		return err
+	}
+
+	ln, err := net.Listen("tcp", s.config.Listen)
+	if err != nil {
+		close(out)
+		return fmt.Errorf("listen on %s: %w", s.config.Listen, err)
+	}
+	srv := &http.Server{Handler: handler}
+
+	fmt.Fprintf(os.Stderr, "[recall/http-llm] proxying http://%s → %s\n", ln.Addr(), s.config.Upstream)
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(ln) }()
+
+	select {
+	case <-ctx.Done():
+		err = nil
+	case err = <-serveErr:
+		err = fmt.Errorf("serve: %w", err)
+	}
+
+	// Give in-flight streams a moment to finish, then cut them off.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if srv.Shutdown(shutdownCtx) != nil {
+		srv.Close()
+	}
+	s.inflight.Wait()
+
+	// CRITICAL: Source owns the channel lifecycle. We must close it.
+	close(out)
+	return err
+}
+
+// Handler returns the capturing reverse proxy as an http.Handler, so it can
+// be mounted on any server (including httptest servers in front of a stub
+// upstream). Messages are sent to out until ctx is cancelled.
+func (s *Source) Handler(ctx context.Context, out chan<- source.Message) (http.Handler, error) {
+	target, err := url.Parse(s.config.Upstream)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return nil, fmt.Errorf("invalid upstream URL %q", s.config.Upstream)
+	}
+
+	capture := &httpcapture.Capture{
+		SourceName:        s.Name(),
+		RequestDirection:  "upstream",
+		ResponseDirection: "downstream",
+		Host:              target.Host,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+		},
+		SessionID: func(r *http.Request, body []byte, exchangeID string) string {
+			if id := r.Header.Get(s.config.ConversationHeader); id != "" {
+				return id
+			}
+			if id := httpcapture.DeriveSessionID(body); id != "" {
+				return id
+			}
+			return "llm-" + exchangeID
+		},
+		Emit: func(msg source.Message) {
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+			}
+		},
+	}
+	handler := capture.Handler()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.inflight.Add(1)
+		defer s.inflight.Done()
+		handler.ServeHTTP(w, r)
+	}), nil
+}
@@ -0,0 +1,146 @@
+package httpllm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shshwtsuthar/recall/source"
+)
+
+const reply = `{"id":"msg_1","content":[{"type":"text","text":"hi"}]}`
+
+// stub plays the model API.
+func stub(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/json":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, reply)
+		case "/v1/sse":
+			w.Header().Set("Content-Type", "text/event-stream")
+			for i := 0; i < 3; i++ {
+				fmt.Fprintf(w, "data: {\"n\":%d}\n\n", i)
+				w.(http.Flusher).Flush()
+			}
+		case "/v1/gzip":
+			if got := r.Header.Get("Accept-Encoding"); got != "gzip" {
+				t.Errorf("upstream Accept-Encoding = %q, want gzip", got)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			io.WriteString(gz, reply)
+			gz.Close()
+		}
+	}))
+}
+
+// exchange sends one request through the proxy and returns what the client
+// received and the captured response message.
+func exchange(t *testing.T, path string, header http.Header) ([]byte, map[string]any) {
+	t.Helper()
+	upstream := stub(t)
+	defer upstream.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan source.Message, 4)
+	handler, err := New(Config{Upstream: upstream.URL}).Handler(ctx, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+
+	req, _ := http.NewRequest("POST", proxy.URL+path, strings.NewReader(`{"messages":[{"role":"user","content":"hello"}]}`))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs []source.Message
+	for len(msgs) < 2 {
+		select {
+		case msg := <-out:
+			msgs = append(msgs, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d messages, want 2", len(msgs))
+		}
+	}
+	if msgs[0].Direction != "upstream" || msgs[1].Direction != "downstream" || msgs[0].SessionID != msgs[1].SessionID {
+		t.Errorf("got %s/%s in sessions %q and %q, want one upstream/downstream pair",
+			msgs[0].Direction, msgs[1].Direction, msgs[0].SessionID, msgs[1].SessionID)
+	}
+	var captured map[string]any
+	if err := json.Unmarshal([]byte(msgs[1].Raw), &captured); err != nil {
+		t.Fatalf("captured response is not JSON: %v", err)
+	}
+	return body, captured
+}
+
+func TestJSONResponse(t *testing.T) {
+	body, captured := exchange(t, "/v1/json", nil)
+	if string(body) != reply {
+		t.Errorf("client got %s, want %s", body, reply)
+	}
+	if got, _ := json.Marshal(captured["body"]); !jsonEqual(got, reply) {
+		t.Errorf("captured body %s, want %s", got, reply)
+	}
+}
+
+func TestSSEResponse(t *testing.T) {
+	const events = "data: {\"n\":0}\n\ndata: {\"n\":1}\n\ndata: {\"n\":2}\n\n"
+	body, captured := exchange(t, "/v1/sse", nil)
+	if string(body) != events {
+		t.Errorf("client got %q, want %q", body, events)
+	}
+	if captured["body"] != events {
+		t.Errorf("captured body %q, want %q", captured["body"], events)
+	}
+}
+
+func TestGzipResponse(t *testing.T) {
+	body, captured := exchange(t, "/v1/gzip", http.Header{"Accept-Encoding": {"gzip, br"}})
+
+	// The client gets the compressed bytes it asked for, untouched.
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("client body is not gzip: %v", err)
+	}
+	if plain, _ := io.ReadAll(gz); string(plain) != reply {
+		t.Errorf("client got %s, want %s", plain, reply)
+	}
+	// The captured copy is decompressed.
+	if got, _ := json.Marshal(captured["body"]); !jsonEqual(got, reply) {
+		t.Errorf("captured body %s, want %s", got, reply)
+	}
+	if _, ok := captured["content_encoding"]; ok {
+		t.Errorf("captured body still marked encoded: %v", captured["content_encoding"])
+	}
+}
+
+func jsonEqual(a []byte, b string) bool {
+	var x, y any
+	json.Unmarshal(a, &x)
+	json.Unmarshal([]byte(b), &y)
+	xs, _ := json.Marshal(x)
+	ys, _ := json.Marshal(y)
+	return bytes.Equal(xs, ys)
+}
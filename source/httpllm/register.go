@@ -0,0 +1,22 @@
+package httpllm
+
+import "github.com/shshwtsuthar/recall/source"
+
+func init() {
+	source.Register(source.Descriptor{
+		Name:        "http-llm",
+		Description: "Reverse proxy in front of an OpenAI/Anthropic-compatible HTTP API",
+		Flags: []source.Flag{
+			{Name: "upstream", Usage: "real API base URL, e.g. https://api.anthropic.com", Required: true},
+			{Name: "listen", Usage: "local listen address", Default: DefaultListen},
+			{Name: "conversation-header", Usage: "request header carrying the session id", Default: DefaultConversationHeader},
+		},
+		New: func(opts source.Options) (source.Source, error) {
+			return New(Config{
+				Upstream:           opts.String("upstream"),
+				Listen:             opts.String("listen"),
+				ConversationHeader: opts.String("conversation-header"),
+			}), nil
+		},
+	})
+}
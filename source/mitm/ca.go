@@ -0,0 +1,224 @@
+package mitm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 7 * 24 * time.Hour
+)
+
+// systemBundles are where common platforms keep their trusted root bundle.
+// The first one found is concatenated with our CA so that tools which
+// replace (rather than extend) their trust store via SSL_CERT_FILE keep
+// trusting everything else.
+var systemBundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",                // Debian, Ubuntu, Arch, Alpine
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem", // Fedora, RHEL
+	"/etc/pki/tls/certs/ca-bundle.crt",                  // older RHEL, CentOS
+	"/etc/ssl/ca-bundle.pem",                            // openSUSE
+	"/etc/ssl/cert.pem",                                 // macOS, BSD
+}
+
+// CA is recall's per-install certificate authority. It is generated once,
+// stored in recall's data directory, and only ever trusted by agent processes
+// that recall itself launches — it is never added to the system trust store.
+type CA struct {
+	cert       *x509.Certificate
+	key        *ecdsa.PrivateKey
+	certPath   string
+	bundlePath string
+
+	mu     sync.Mutex
+	leaves map[string]*tls.Certificate
+}
+
+// LoadOrCreateCA loads the CA from dir, generating it on first use.
+// It also (re)writes a trust bundle of system roots plus the CA.
+func LoadOrCreateCA(dir string) (*CA, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create CA dir: %w", err)
+	}
+	ca := &CA{
+		certPath:   filepath.Join(dir, "ca.pem"),
+		bundlePath: filepath.Join(dir, "ca-bundle.pem"),
+		leaves:     make(map[string]*tls.Certificate),
+	}
+	keyPath := filepath.Join(dir, "ca-key.pem")
+
+	certPEM, certErr := os.ReadFile(ca.certPath)
+	keyPEM, keyErr := os.ReadFile(keyPath)
+	switch {
+	case certErr == nil && keyErr == nil:
+		if err := ca.parse(certPEM, keyPEM); err != nil {
+			return nil, fmt.Errorf("load CA from %s: %w", dir, err)
+		}
+	case errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist):
+		var err error
+		if certPEM, keyPEM, err = generateCA(); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+			return nil, fmt.Errorf("write CA key: %w", err)
+		}
+		if err := os.WriteFile(ca.certPath, certPEM, 0o644); err != nil {
+			return nil, fmt.Errorf("write CA cert: %w", err)
+		}
+		if err := ca.parse(certPEM, keyPEM); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "[recall/mitm] generated local CA %s\n", ca.certPath)
+	default:
+		return nil, fmt.Errorf("CA in %s is incomplete; delete ca.pem and ca-key.pem to regenerate", dir)
+	}
+
+	if err := ca.writeBundle(certPEM); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// CertPath is the PEM file containing only the CA certificate.
+func (ca *CA) CertPath() string {
+	return ca.certPath
+}
+
+// BundlePath is the PEM file containing the system roots plus the CA.
+func (ca *CA) BundlePath() string {
+	return ca.bundlePath
+}
+
+func (ca *CA) parse(certPEM, keyPEM []byte) error {
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return fmt.Errorf("invalid PEM")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return fmt.Errorf("parse CA cert: %w", err)
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return fmt.Errorf("parse CA key: %w", err)
+	}
+	ca.cert, ca.key = cert, key
+	return nil
+}
+
+func generateCA() (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate CA key: %w", err)
+	}
+	host, _ := os.Hostname()
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject: pkix.Name{
+			Organization: []string{"recall"},
+			CommonName:   "recall local CA (" + host + ")",
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create CA cert: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal CA key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		nil
+}
+
+func (ca *CA) writeBundle(certPEM []byte) error {
+	var bundle []byte
+	candidates := systemBundles
+	if current := os.Getenv("SSL_CERT_FILE"); current != "" {
+		candidates = append([]string{current}, candidates...)
+	}
+	for _, path := range candidates {
+		if data, err := os.ReadFile(path); err == nil {
+			bundle = append(data, '\n')
+			break
+		}
+	}
+	bundle = append(bundle, certPEM...)
+	if err := os.WriteFile(ca.bundlePath, bundle, 0o644); err != nil {
+		return fmt.Errorf("write CA bundle: %w", err)
+	}
+	return nil
+}
+
+// leaf returns a certificate for host signed by the CA, cached until close
+// to expiry.
+func (ca *CA) leaf(host string) (*tls.Certificate, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if cert, ok := ca.leaves[host]; ok && time.Until(cert.Leaf.NotAfter) > time.Hour {
+		return cert, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate leaf key: %w", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("sign leaf for %s: %w", host, err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	ca.leaves[host] = cert
+	return cert, nil
+}
+
+func randomSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	return serial
+}
@@ -0,0 +1,422 @@
+// Package mitm is an HTTPS forward proxy that lets recall see the requests an
+// agent sends to its model API, not just what it tells the editor.
+//
+// The proxy accepts CONNECT requests. Connections to known LLM API hosts are
+// terminated with a certificate issued by recall's per-install CA and
+// forwarded through package httpcapture; every other host is tunnelled
+// byte-for-byte without being decrypted. Only agent processes launched by
+// recall are configured to use the proxy and trust the CA (see Env).
+//
+// Outgoing connections, intercepted or tunnelled, go through the proxy set in
+// recall's own environment (HTTPS_PROXY, NO_PROXY), so a corporate proxy the
+// agent would have used is still used.
+package mitm
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shshwtsuthar/recall/source"
+	"github.com/shshwtsuthar/recall/source/httpcapture"
+)
+
+// Direction values for captured model API traffic.
+const (
+	ModelRequest  = "model_request"  // agent → LLM API
+	ModelResponse = "model_response" // LLM API → agent
+)
+
+// DefaultHosts are the model API hosts intercepted when Config.Hosts is empty.
+var DefaultHosts = []string{
+	"api.anthropic.com",
+	"api.openai.com",
+	"generativelanguage.googleapis.com",
+	"cloudcode-pa.googleapis.com",
+	"api.mistral.ai",
+	"api.groq.com",
+	"api.deepseek.com",
+	"api.x.ai",
+	"openrouter.ai",
+}
+
+// Config holds forward proxy configuration.
+type Config struct {
+	// SourceName is stamped on captured messages and used as the log prefix.
+	SourceName string
+
+	// CA issues the certificates presented for intercepted hosts.
+	CA *CA
+
+	// Hosts lists the hostnames to intercept. Defaults to DefaultHosts.
+	Hosts []string
+
+	// SessionID returns the session that captured exchanges belong to,
+	// typically the current ACP session of the agent using the proxy.
+	SessionID func() string
+
+	// Emit receives every captured message. It may block.
+	Emit func(source.Message)
+}
+
+// Proxy is a running forward proxy bound to a loopback port.
+type Proxy struct {
+	config  Config
+	hosts   map[string]bool
+	ln      net.Listener
+	srv     *http.Server
+	handler http.Handler
+
+	wg      sync.WaitGroup
+	connsMu sync.Mutex
+	conns   map[net.Conn]struct{}
+	closed  bool
+}
+
+// Start listens on a random loopback port and begins serving.
+func Start(config Config) (*Proxy, error) {
+	if len(config.Hosts) == 0 {
+		config.Hosts = DefaultHosts
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	p := &Proxy{
+		config: config,
+		hosts:  make(map[string]bool, len(config.Hosts)),
+		ln:     ln,
+		conns:  make(map[net.Conn]struct{}),
+	}
+	for _, h := range config.Hosts {
+		p.hosts[strings.ToLower(h)] = true
+	}
+
+	// The clone keeps http.ProxyFromEnvironment, chaining intercepted
+	// requests through the original proxy.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	capture := &httpcapture.Capture{
+		SourceName:        config.SourceName,
+		RequestDirection:  ModelRequest,
+		ResponseDirection: ModelResponse,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			// Inside the TLS tunnel the request is origin-form ("/v1/messages");
+			// send it to exactly the host and port the client CONNECTed to,
+			// which is what the allowlist was checked against.
+			pr.Out.URL.Scheme = "https"
+			pr.Out.URL.Host = pr.In.Context().Value(connectKey{}).(connectTarget).target
+			pr.Out.Host = pr.In.Host
+		},
+		SessionID: func(*http.Request, []byte, string) string {
+			return config.SessionID()
+		},
+		Emit:      config.Emit,
+		Transport: transport,
+	}
+	inner := capture.Handler()
+	p.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !strings.EqualFold(host, r.Context().Value(connectKey{}).(connectTarget).hostname) {
+			http.Error(w, "Host does not match the CONNECT target", http.StatusMisdirectedRequest)
+			return
+		}
+		// Always nested inside a live CONNECT, so the counter is non-zero here.
+		p.wg.Add(1)
+		defer p.wg.Done()
+		inner.ServeHTTP(w, r)
+	})
+
+	p.srv = &http.Server{Handler: http.HandlerFunc(p.serveConnect)}
+	go p.srv.Serve(ln)
+	return p, nil
+}
+
+// URL is the proxy URL to put in HTTPS_PROXY.
+func (p *Proxy) URL() string {
+	return "http://" + p.ln.Addr().String()
+}
+
+// Env returns the environment an agent needs to route its HTTPS traffic
+// through the proxy and trust the CA. It covers Node (the runtime of most
+// CLI agents), Python, curl/OpenSSL and Go. It replaces the agent's
+// HTTPS_PROXY; the proxy forwards to that one in turn.
+func (p *Proxy) Env() []string {
+	bundle := p.config.CA.BundlePath()
+	return []string{
+		"HTTPS_PROXY=" + p.URL(),
+		"https_proxy=" + p.URL(),
+		"NODE_USE_ENV_PROXY=1",
+		"NODE_EXTRA_CA_CERTS=" + p.config.CA.CertPath(),
+		"SSL_CERT_FILE=" + bundle,
+		"REQUESTS_CA_BUNDLE=" + bundle,
+		"CURL_CA_BUNDLE=" + bundle,
+	}
+}
+
+// Close stops accepting connections, closes open tunnels and waits for
+// in-flight exchanges to finish emitting.
+func (p *Proxy) Close() {
+	p.srv.Close()
+	p.connsMu.Lock()
+	p.closed = true
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.connsMu.Unlock()
+	p.wg.Wait()
+}
+
+// serveConnect handles one CONNECT request.
+func (p *Proxy) serveConnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	target := r.Host
+	hostname, _, err := net.SplitHostPort(target)
+	if err != nil {
+		hostname, target = target, net.JoinHostPort(target, "443")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking unsupported", http.StatusInternalServerError)
+		return
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	if !p.track(conn) {
+		return
+	}
+	p.wg.Add(1)
+	defer p.wg.Done()
+	defer p.untrack(conn)
+
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		conn.Close()
+		return
+	}
+
+	if p.hosts[strings.ToLower(hostname)] {
+		p.intercept(conn, connectTarget{target: target, hostname: hostname})
+	} else {
+		p.tunnel(conn, target)
+	}
+}
+
+// connectTarget is the destination of one CONNECT request.
+type connectTarget struct {
+	target   string // host:port, as dialled upstream
+	hostname string // target without the port
+}
+
+// connectKey is the context key of the connectTarget an intercepted request
+// arrived through.
+type connectKey struct{}
+
+// intercept terminates TLS with a CA-issued certificate and serves the
+// decrypted HTTP through the capturing reverse proxy. The certificate is
+// only ever issued for the CONNECT target's hostname.
+func (p *Proxy) intercept(conn net.Conn, dest connectTarget) {
+	hostname := dest.hostname
+	tlsConn := tls.Server(conn, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "" && !strings.EqualFold(hello.ServerName, hostname) {
+				return nil, fmt.Errorf("SNI %q does not match CONNECT target %q", hello.ServerName, hostname)
+			}
+			return p.config.CA.leaf(hostname)
+		},
+		NextProtos: []string{"http/1.1"},
+	})
+	tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := tlsConn.Handshake(); err != nil {
+		fmt.Fprintf(os.Stderr, "[recall/%s] TLS handshake for %s failed (does the agent trust the recall CA?): %v\n",
+			p.config.SourceName, hostname, err)
+		tlsConn.Close()
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	srv := &http.Server{
+		Handler: p.handler,
+		ConnContext: func(ctx context.Context, _ net.Conn) context.Context {
+			return context.WithValue(ctx, connectKey{}, dest)
+		},
+	}
+	srv.Serve(newOneConnListener(tlsConn))
+}
+
+// tunnel relays bytes to target without decrypting anything.
+func (p *Proxy) tunnel(conn net.Conn, target string) {
+	upstream, err := dialUpstream(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[recall/%s] tunnel to %s: %v\n", p.config.SourceName, target, err)
+		conn.Close()
+		return
+	}
+	if !p.track(upstream) {
+		conn.Close()
+		return
+	}
+	defer p.untrack(upstream)
+
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(upstream, conn); done <- struct{}{} }()
+	go func() { io.Copy(conn, upstream); done <- struct{}{} }()
+	<-done
+	conn.Close()
+	upstream.Close()
+	<-done
+}
+
+// dialUpstream connects to target, through the proxy recall's environment
+// names for it if there is one.
+func dialUpstream(target string) (net.Conn, error) {
+	const timeout = 10 * time.Second
+	proxyURL, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: target}})
+	if err != nil {
+		return nil, fmt.Errorf("upstream proxy: %w", err)
+	}
+	if proxyURL == nil {
+		return net.DialTimeout("tcp", target, timeout)
+	}
+
+	addr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+	var conn net.Conn
+	switch proxyURL.Scheme {
+	case "http":
+		conn, err = net.DialTimeout("tcp", addr, timeout)
+	case "https":
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr,
+			&tls.Config{ServerName: proxyURL.Hostname()})
+	default:
+		return nil, fmt.Errorf("unsupported upstream proxy scheme %q", proxyURL.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dial upstream proxy: %w", err)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if u := proxyURL.User; u != nil {
+		password, _ := u.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("upstream proxy CONNECT: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("upstream proxy CONNECT: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("upstream proxy CONNECT: %s", resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		// The target spoke first and the reader already holds its bytes.
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn whose first bytes were read into r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+// track registers conn so Close can interrupt it. It returns false (and
+// closes conn) if the proxy is already shutting down.
+func (p *Proxy) track(conn net.Conn) bool {
+	p.connsMu.Lock()
+	defer p.connsMu.Unlock()
+	if p.closed {
+		conn.Close()
+		return false
+	}
+	p.conns[conn] = struct{}{}
+	return true
+}
+
+func (p *Proxy) untrack(conn net.Conn) {
+	p.connsMu.Lock()
+	defer p.connsMu.Unlock()
+	delete(p.conns, conn)
+}
+
+// oneConnListener hands a single connection to http.Server.Serve and then
+// blocks until that connection is closed, so Serve returns exactly when the
+// connection is done.
+type oneConnListener struct {
+	conn net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func newOneConnListener(conn net.Conn) *oneConnListener {
+	return &oneConnListener{conn: conn, done: make(chan struct{})}
+}
+
+func (l *oneConnListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.once.Do(func() { conn = &notifyConn{Conn: l.conn, done: l.done} })
+	if conn != nil {
+		return conn, nil
+	}
+	<-l.done
+	return nil, net.ErrClosed
+}
+
+func (l *oneConnListener) Close() error   { return nil }
+func (l *oneConnListener) Addr() net.Addr { return l.conn.LocalAddr() }
+
+// notifyConn closes done when the connection is closed.
+type notifyConn struct {
+	net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func (c *notifyConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { close(c.done) })
+	return err
+}
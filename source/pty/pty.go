@@ -0,0 +1,242 @@
+// Package pty implements the Source interface for interactive terminal
+// agents that speak no protocol at all.
+//
+// The agent is launched under a pseudo-terminal. The user's terminal is put
+// into raw mode and relayed byte-for-byte in both directions (window resizes
+// included), so the agent's TUI behaves exactly as if it were run directly.
+// Alongside, keystrokes are reassembled into submitted input lines
+// ("upstream") and the output stream is reduced to readable text lines with
+// ANSI escape sequences stripped and screen repaints collapsed
+// ("downstream"). Each launch of the agent is one session.
+//
+// Only Linux is supported; on other platforms Run returns an error.
+package pty
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/shshwtsuthar/recall/source"
+)
+
+// defaultFlushInterval batches output lines into one message per quiet period.
+const defaultFlushInterval = 500 * time.Millisecond
+
+// Config holds pty-specific configuration.
+type Config struct {
+	// Command is the agent binary and its arguments.
+	Command []string
+
+	// FlushInterval is how often accumulated output lines are emitted.
+	FlushInterval time.Duration
+}
+
+// Source implements the source.Source interface for terminal agents.
+type Source struct {
+	config Config
+}
+
+// New creates a pty source with the given configuration.
+func New(config Config) *Source {
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultFlushInterval
+	}
+	return &Source{config: config}
+}
+
+// AgentCommand implements source.Launcher.
+func (s *Source) AgentCommand() []string {
+	return s.config.Command
+}
+
+// Name returns the identifier for this source type.
+func (s *Source) Name() string {
+	return "pty"
+}
+
+// Run launches the agent under a pseudo-terminal and relays the user's
+// terminal until the agent exits or ctx is cancelled.
+func (s *Source) Run(ctx context.Context, out chan<- source.Message) error {
+	if len(s.config.Command) == 0 {
+		close(out)
+		return fmt.Errorf("no agent command specified")
+	}
+
+	master, slave, err := openPTY()
+	if err != nil {
+		close(out)
+		return err
+	}
+	defer master.Close()
+
+	cmd := exec.CommandContext(ctx, s.config.Command[0], s.config.Command[1:]...)
+	attach(cmd, slave)
+	rows := copySize(os.Stdin.Fd(), master.Fd())
+
+	if err := cmd.Start(); err != nil {
+		slave.Close()
+		close(out)
+		return fmt.Errorf("start agent %q: %w", s.config.Command[0], err)
+	}
+	// The child holds its own copy; ours would keep the pty open after it exits.
+	slave.Close()
+
+	// Raw mode only makes sense when we are attached to a real terminal.
+	if restore, err := makeRaw(os.Stdin.Fd()); err == nil {
+		defer restore()
+	}
+
+	if resizeSignal != nil {
+		resized := make(chan os.Signal, 1)
+		signal.Notify(resized, resizeSignal)
+		defer signal.Stop(resized)
+		// signal.Stop never closes resized, so stop on done instead.
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				select {
+				case <-resized:
+					copySize(os.Stdin.Fd(), master.Fd())
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	sessionID := "pty-" + randomHex(8)
+	fmt.Fprintf(os.Stderr, "[recall/pty] session started: %s\r\n", sessionID)
+
+	var (
+		mu     sync.Mutex // guards screen and input
+		screen = newScreenText(rows)
+		input  = &inputLine{}
+
+		outMu  sync.RWMutex // guards closed; held for reading while sending
+		closed bool
+	)
+	// emit never runs under mu, so a slow pipeline can't stall the relay
+	// goroutines on the screen lock.
+	emit := func(direction string, lines ...string) {
+		outMu.RLock()
+		defer outMu.RUnlock()
+		if closed {
+			return
+		}
+		for _, raw := range lines {
+			if raw == "" {
+				continue
+			}
+			select {
+			case out <- source.Message{
+				Raw:        raw,
+				Direction:  direction,
+				SessionID:  sessionID,
+				SourceName: s.Name(),
+				CapturedAt: time.Now().UTC(),
+			}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+	screenLines := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return strings.Join(screen.Lines(), "\n")
+	}
+
+	// Keystrokes: user terminal → agent. This goroutine may outlive Run,
+	// blocked reading stdin; emit's closed check keeps it harmless.
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if n > 0 {
+				master.Write(buf[:n])
+				mu.Lock()
+				input.Write(buf[:n])
+				lines := input.Lines()
+				mu.Unlock()
+				if len(lines) > 0 {
+					// Output shown before Enter belongs before the prompt.
+					emit("downstream", screenLines())
+					emit("upstream", lines...)
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// Screen output: agent → user terminal.
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := master.Read(buf)
+			if n > 0 {
+				os.Stdout.Write(buf[:n])
+				mu.Lock()
+				screen.Write(buf[:n])
+				mu.Unlock()
+			}
+			if err != nil {
+				// Linux reports EIO once the last slave handle is closed.
+				if !errors.Is(err, io.EOF) && !errors.Is(err, syscall.EIO) {
+					fmt.Fprintf(os.Stderr, "[recall/pty] read error: %v\r\n", err)
+				}
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- cmd.Wait() }()
+
+	var runErr error
+	for running := true; running; {
+		select {
+		case <-ticker.C:
+			emit("downstream", screenLines())
+		case runErr = <-waitErr:
+			running = false
+		}
+	}
+	<-outputDone
+
+	mu.Lock()
+	screen.Flush()
+	mu.Unlock()
+	emit("downstream", screenLines())
+
+	outMu.Lock()
+	closed = true
+	// CRITICAL: Source owns the channel lifecycle. We must close it.
+	close(out)
+	outMu.Unlock()
+
+	return runErr
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
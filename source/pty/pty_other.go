@@ -0,0 +1,28 @@
+//go:build !linux
+
+package pty
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+var errUnsupported = errors.New("the pty source is only supported on Linux")
+
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errUnsupported
+}
+
+func attach(cmd *exec.Cmd, slave *os.File) {}
+
+func makeRaw(fd uintptr) (restore func(), err error) {
+	return nil, errUnsupported
+}
+
+func copySize(from, to uintptr) int {
+	return 0
+}
+
+// resizeSignal is nil where window resizing is not supported.
+var resizeSignal os.Signal
@@ -0,0 +1,18 @@
+package pty
+
+import "github.com/shshwtsuthar/recall/source"
+
+func init() {
+	source.Register(source.Descriptor{
+		Name:        "pty",
+		Description: "Run an interactive terminal agent under a pseudo-terminal (Linux)",
+		Flags: []source.Flag{
+			{Name: "agent", Usage: "agent binary to launch", Required: true},
+		},
+		PassThrough: true,
+		UsesStdio:   true,
+		New: func(opts source.Options) (source.Source, error) {
+			return New(Config{Command: append([]string{opts.String("agent")}, opts.Args...)}), nil
+		},
+	})
+}
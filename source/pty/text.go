@@ -0,0 +1,308 @@
+package pty
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// screenText turns a raw terminal output stream into readable lines.
+//
+// TUIs rarely print plain lines: they move the cursor, overwrite the current
+// line with "\r", erase with CSI K and repaint whole regions. screenText keeps
+// a single virtual line with a cursor, applies the editing operations that
+// matter for the text itself, strips every other escape sequence, and commits
+// the line on "\n" or whenever the cursor leaves it.
+//
+// Lines committed while repainting (after the cursor moved up or jumped to an
+// absolute position) are dropped if they repeat one of the recent lines, so a
+// redrawn screen does not produce a second copy of itself. Ordinary scrolling
+// output is kept verbatim, duplicates included.
+type screenText struct {
+	line   []rune
+	cursor int
+
+	// esc buffers an escape sequence split across reads.
+	esc []byte
+
+	// pending holds bytes of a UTF-8 character split across reads.
+	pending []byte
+
+	// redrawing is set by cursor-up/absolute positioning and cleared once a
+	// screenful of lines has been written without another repaint.
+	redrawing   bool
+	sinceRedraw int
+
+	recent []string
+	window int
+
+	lines []string
+}
+
+func newScreenText(rows int) *screenText {
+	if rows <= 0 {
+		rows = 50
+	}
+	return &screenText{window: rows}
+}
+
+// Write feeds raw terminal output. It never fails.
+func (s *screenText) Write(p []byte) (int, error) {
+	data := append(s.pending, p...)
+	s.pending = nil
+
+	for i := 0; i < len(data); {
+		b := data[i]
+
+		if s.esc != nil {
+			s.esc = append(s.esc, b)
+			i++
+			if done, final := escapeComplete(s.esc); done {
+				s.applyEscape(s.esc, final)
+				s.esc = nil
+			}
+			continue
+		}
+
+		switch b {
+		case 0x1b:
+			s.esc = []byte{b}
+			i++
+			continue
+		case '\n':
+			s.commit()
+		case '\r':
+			s.cursor = 0
+		case '\b':
+			if s.cursor > 0 {
+				s.cursor--
+			}
+		case '\t':
+			s.put(' ')
+		default:
+			if b < 0x20 || b == 0x7f {
+				// Bell and other controls carry no text.
+				break
+			}
+			if !utf8.FullRune(data[i:]) {
+				s.pending = append([]byte(nil), data[i:]...)
+				return len(p), nil
+			}
+			r, size := utf8.DecodeRune(data[i:])
+			s.put(r)
+			i += size
+			continue
+		}
+		i++
+	}
+	return len(p), nil
+}
+
+// Lines returns and clears the committed lines.
+func (s *screenText) Lines() []string {
+	lines := s.lines
+	s.lines = nil
+	return lines
+}
+
+// Flush commits the current partial line, e.g. when the process exits.
+func (s *screenText) Flush() {
+	s.commit()
+}
+
+func (s *screenText) put(r rune) {
+	if s.cursor < len(s.line) {
+		s.line[s.cursor] = r
+	} else {
+		for len(s.line) < s.cursor {
+			s.line = append(s.line, ' ')
+		}
+		s.line = append(s.line, r)
+	}
+	s.cursor++
+}
+
+// commit finishes the current virtual line.
+func (s *screenText) commit() {
+	text := strings.TrimRight(string(s.line), " ")
+	s.line, s.cursor = s.line[:0], 0
+	if text == "" {
+		return
+	}
+	if n := len(s.lines); n > 0 && s.lines[n-1] == text {
+		return
+	}
+	if s.redrawing {
+		if s.sinceRedraw++; s.sinceRedraw > s.window {
+			s.redrawing = false
+		}
+		if s.isRecent(text) {
+			return
+		}
+	}
+	s.lines = append(s.lines, text)
+	s.recent = append(s.recent, text)
+	if len(s.recent) > s.window {
+		s.recent = s.recent[len(s.recent)-s.window:]
+	}
+}
+
+func (s *screenText) isRecent(text string) bool {
+	for _, r := range s.recent {
+		if r == text {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeComplete reports whether seq (starting with ESC) is a complete escape
+// sequence, and its final byte.
+func escapeComplete(seq []byte) (bool, byte) {
+	if len(seq) < 2 {
+		return false, 0
+	}
+	last := seq[len(seq)-1]
+	switch seq[1] {
+	case '[': // CSI: parameters, then a final byte in 0x40–0x7E
+		if len(seq) > 2 && last >= 0x40 && last <= 0x7e {
+			return true, last
+		}
+		return len(seq) > 64, 0
+	case ']', 'P', '_', '^': // OSC/DCS/APC/PM: terminated by BEL or ESC \
+		if last == 0x07 || (len(seq) > 2 && seq[len(seq)-2] == 0x1b && last == '\\') {
+			return true, 0
+		}
+		return len(seq) > 4096, 0
+	case '(', ')', '*', '+', '#', '%': // charset selection and friends take one more byte
+		return len(seq) >= 3, 0
+	default: // two-byte sequences such as ESC 7, ESC 8, ESC M
+		return true, seq[1]
+	}
+}
+
+// applyEscape applies the few sequences that change the text of the line.
+func (s *screenText) applyEscape(seq []byte, final byte) {
+	if len(seq) < 2 {
+		return
+	}
+	if seq[1] != '[' {
+		switch final {
+		case 'M': // reverse index: cursor up
+			s.commit()
+			s.markRedraw()
+		}
+		return
+	}
+
+	params := string(seq[2 : len(seq)-1])
+	switch final {
+	case 'K': // erase in line
+		switch params {
+		case "", "0":
+			if s.cursor < len(s.line) {
+				s.line = s.line[:s.cursor]
+			}
+		case "1":
+			for i := 0; i < s.cursor && i < len(s.line); i++ {
+				s.line[i] = ' '
+			}
+		case "2":
+			s.line = s.line[:0]
+		}
+	case 'G': // cursor to column
+		s.cursor = max(atoiDefault(params, 1)-1, 0)
+	case 'C': // cursor forward
+		s.cursor += max(atoiDefault(params, 1), 1)
+	case 'D': // cursor back
+		s.cursor = max(s.cursor-max(atoiDefault(params, 1), 1), 0)
+	case 'A', 'F': // cursor up: repainting earlier lines
+		s.commit()
+		s.markRedraw()
+	case 'B', 'E': // cursor down
+		s.commit()
+	case 'H', 'f', 'd': // absolute positioning
+		s.commit()
+		s.markRedraw()
+	case 'J': // erase display
+		s.commit()
+		s.markRedraw()
+	}
+}
+
+func (s *screenText) markRedraw() {
+	s.redrawing = true
+	s.sinceRedraw = 0
+}
+
+// atoiDefault parses a single numeric CSI parameter.
+func atoiDefault(s string, def int) int {
+	if s == "" {
+		return def
+	}
+	n := 0
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return def
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n
+}
+
+// inputLine reconstructs what the user typed from raw keystrokes.
+// It understands Enter, backspace and Ctrl-U; arrow keys and other escape
+// sequences are ignored, so in-line editing with the cursor is best-effort.
+type inputLine struct {
+	buf []rune
+	esc []byte
+
+	lines []string
+}
+
+func (l *inputLine) Write(p []byte) (int, error) {
+	for i := 0; i < len(p); {
+		b := p[i]
+		if l.esc != nil {
+			l.esc = append(l.esc, b)
+			i++
+			if done, _ := escapeComplete(l.esc); done {
+				l.esc = nil
+			}
+			continue
+		}
+		switch b {
+		case 0x1b:
+			l.esc = []byte{b}
+		case '\r', '\n':
+			if text := strings.TrimSpace(string(l.buf)); text != "" {
+				l.lines = append(l.lines, text)
+			}
+			l.buf = l.buf[:0]
+		case 0x7f, '\b':
+			if len(l.buf) > 0 {
+				l.buf = l.buf[:len(l.buf)-1]
+			}
+		case 0x15: // Ctrl-U
+			l.buf = l.buf[:0]
+		case 0x03: // Ctrl-C
+			l.lines = append(l.lines, "^C")
+			l.buf = l.buf[:0]
+		default:
+			if b >= 0x20 {
+				r, size := utf8.DecodeRune(p[i:])
+				l.buf = append(l.buf, r)
+				i += size
+				continue
+			}
+		}
+		i++
+	}
+	return len(p), nil
+}
+
+// Lines returns and clears the completed input lines.
+func (l *inputLine) Lines() []string {
+	lines := l.lines
+	l.lines = nil
+	return lines
+}
@@ -0,0 +1,178 @@
+package source
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Flag describes one source-specific command-line flag.
+// Flags are written as --name on the command line.
+type Flag struct {
+	// Name is the flag name without leading dashes, e.g. "agent".
+	Name string
+
+	// Usage is a one-line description shown by `recall sources`.
+	Usage string
+
+	// Default is the value reported when the flag is not given.
+	Default string
+
+	// Required flags must be given at least once.
+	Required bool
+
+	// Bool flags take no value; their presence means "true".
+	Bool bool
+
+	// Repeated flags may be given more than once; all values are kept.
+	Repeated bool
+}
+
+// Descriptor is what a source package registers: a name, the flags it
+// accepts and a constructor. main builds sources exclusively through the
+// registry, so a source compiled into the binary needs no changes to main.
+type Descriptor struct {
+	// Name is the value passed to --source, e.g. "acp".
+	Name string
+
+	// Description is a one-line summary shown by `recall sources`.
+	Description string
+
+	// Flags lists the flags this source accepts.
+	Flags []Flag
+
+	// PassThrough reports whether the source accepts arguments after "--"
+	// (typically forwarded to the agent it launches).
+	PassThrough bool
+
+	// UsesStdio reports whether the source takes over recall's own stdin and
+	// stdout (an editor's pipe or the user's terminal). At most one such
+	// source can run at a time.
+	UsesStdio bool
+
+	// New builds the source from parsed options.
+	New func(opts Options) (Source, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Descriptor)
+)
+
+// Register makes a source available by name. It is intended to be called
+// from an init function and panics if the name is taken or the descriptor
+// is incomplete.
+func Register(d Descriptor) {
+	if d.Name == "" || d.New == nil {
+		panic("source: Register requires a name and a constructor")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[d.Name]; dup {
+		panic("source: Register called twice for " + d.Name)
+	}
+	registry[d.Name] = d
+}
+
+// Lookup returns the descriptor registered under name.
+func Lookup(name string) (Descriptor, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	d, ok := registry[name]
+	return d, ok
+}
+
+// Registered returns all registered descriptors sorted by name.
+func Registered() []Descriptor {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	all := make([]Descriptor, 0, len(registry))
+	for _, d := range registry {
+		all = append(all, d)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// Flag returns the descriptor's flag with the given name.
+func (d Descriptor) Flag(name string) (Flag, bool) {
+	for _, f := range d.Flags {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Flag{}, false
+}
+
+// Options holds the flag values parsed for one source.
+type Options struct {
+	flags  []Flag
+	values map[string][]string
+
+	// Args are the arguments given after "--".
+	Args []string
+}
+
+// NewOptions starts an empty option set for d. Use Set to add values and
+// Validate once all flags are parsed.
+func NewOptions(d Descriptor) Options {
+	return Options{flags: d.Flags, values: make(map[string][]string)}
+}
+
+// Set records a value for a flag. Bool flags are set with value "true".
+func (o Options) Set(name, value string) {
+	o.values[name] = append(o.values[name], value)
+}
+
+// Validate checks required and non-repeatable flags.
+func (o Options) Validate(source string) error {
+	for _, f := range o.flags {
+		n := len(o.values[f.Name])
+		if f.Required && n == 0 {
+			return fmt.Errorf("%s source requires --%s", source, f.Name)
+		}
+		if !f.Repeated && n > 1 {
+			return fmt.Errorf("--%s may only be given once", f.Name)
+		}
+	}
+	return nil
+}
+
+// String returns the flag's value, or its default if it was not given.
+func (o Options) String(name string) string {
+	if v := o.values[name]; len(v) > 0 {
+		return v[len(v)-1]
+	}
+	for _, f := range o.flags {
+		if f.Name == name {
+			return f.Default
+		}
+	}
+	return ""
+}
+
+// Strings returns every value given for a repeated flag.
+func (o Options) Strings(name string) []string {
+	return o.values[name]
+}
+
+// Bool reports whether a bool flag was given.
+func (o Options) Bool(name string) bool {
+	v, _ := strconv.ParseBool(o.String(name))
+	return v
+}
+
+// Int parses the flag's value as an integer (0 if unset).
+func (o Options) Int(name string) (int, error) {
+	s := o.String(name)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("--%s must be a number, got %q", name, s)
+	}
+	return n, nil
+}
@@ -47,6 +47,12 @@ type Message struct {
 	//  - "upstream": IDE/user → Agent
 	//  - "downstream": Agent → IDE/user
 	//  - "log": Unidirectional log entries (e.g., from file tailing)
+	//  - "model_request": Agent → LLM API (captured by an HTTPS forward proxy)
+	//  - "model_response": LLM API → Agent
+	//  - "git": Repository observations linked to the session (see pipes/gitlink)
+	//  - "outcome": Labels computed after the session (see pipes/retention)
+	//  - "control": Records about capture itself, e.g. a suppressed session
+	//    (see pipes/optout)
 	Direction string
 
 	// SessionID groups related messages into a single trajectory.
@@ -58,6 +64,12 @@ type Message struct {
 	// Empty string is valid for sources that don't support session grouping.
 	SessionID string
 
+	// Workspace is the absolute path of the directory the session operates
+	// in (the ACP session's cwd, the aider repo, the VS Code workspace), or
+	// "" when the source doesn't know it. Components that look at the
+	// user's repository (e.g. commit linking) key off this field.
+	Workspace string
+
 	// SourceName identifies which source produced this message.
 	// Populated by the source's Name() method.
 	SourceName string
@@ -65,4 +77,34 @@ type Message struct {
 	// CapturedAt is when the source intercepted this message.
 	// Should be set to time.Now().UTC() at capture time.
 	CapturedAt time.Time
+
+	// Meta holds key/value labels added by pipeline stages (see pipes/enrich)
+	// and transmitted with the message. Sources leave it nil. Stages must
+	// replace the map rather than modify it, since fanned-out copies of a
+	// message share it.
+	Meta map[string]string
+}
+
+// Opposite returns the direction the reply to a message travelling in
+// direction takes: upstream and downstream swap, as do model_request and
+// model_response. Other directions are returned unchanged.
+func Opposite(direction string) string {
+	switch direction {
+	case "upstream":
+		return "downstream"
+	case "downstream":
+		return "upstream"
+	case "model_request":
+		return "model_response"
+	case "model_response":
+		return "model_request"
+	}
+	return direction
+}
+
+// Launcher is implemented by sources that start the agent themselves. The
+// pipeline uses it to describe the agent (see pipes/enrich).
+type Launcher interface {
+	// AgentCommand returns the agent binary and its arguments.
+	AgentCommand() []string
 }
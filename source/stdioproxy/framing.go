@@ -0,0 +1,82 @@
+package stdioproxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Framing describes how individual messages are delimited on a stdio stream.
+//
+// The proxy uses Split to cut the incoming byte stream into message payloads
+// and Write to re-emit each payload on the other side with identical framing,
+// so neither peer can tell the proxy is there.
+type Framing struct {
+	// Split tokenizes the stream into message payloads (without framing bytes).
+	Split bufio.SplitFunc
+
+	// Write emits a single payload, re-applying the framing.
+	Write func(w io.Writer, payload string) error
+}
+
+// LineFraming is newline-delimited JSON-RPC, as used by ACP and MCP stdio.
+// Each message is a single line terminated by "\n".
+var LineFraming = Framing{
+	Split: bufio.ScanLines,
+	Write: func(w io.Writer, payload string) error {
+		_, err := fmt.Fprintln(w, payload)
+		return err
+	},
+}
+
+// ContentLengthFraming is the LSP-style "Content-Length: N\r\n\r\n<body>" framing.
+// Other headers (e.g. Content-Type) are accepted on input and dropped on output.
+var ContentLengthFraming = Framing{
+	Split: splitContentLength,
+	Write: func(w io.Writer, payload string) error {
+		_, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(payload), payload)
+		return err
+	},
+}
+
+// splitContentLength is a bufio.SplitFunc for Content-Length framed streams.
+func splitContentLength(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	headerEnd := bytes.Index(data, []byte("\r\n\r\n"))
+	if headerEnd < 0 {
+		if atEOF && len(bytes.TrimSpace(data)) > 0 {
+			return 0, nil, fmt.Errorf("truncated header")
+		}
+		if atEOF {
+			return len(data), nil, nil
+		}
+		return 0, nil, nil
+	}
+
+	length := -1
+	for _, line := range strings.Split(string(data[:headerEnd]), "\r\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 0 {
+			return 0, nil, fmt.Errorf("invalid Content-Length %q", value)
+		}
+		length = n
+	}
+	if length < 0 {
+		return 0, nil, fmt.Errorf("missing Content-Length header")
+	}
+
+	bodyStart := headerEnd + 4
+	if len(data) < bodyStart+length {
+		if atEOF {
+			return 0, nil, fmt.Errorf("truncated body")
+		}
+		return 0, nil, nil
+	}
+	return bodyStart + length, data[bodyStart : bodyStart+length], nil
+}
@@ -0,0 +1,257 @@
+// Package stdioproxy is the reusable core for sources that sit between an
+// IDE and a JSON-RPC agent speaking over stdio.
+//
+// It owns everything that is protocol-independent: spawning the agent,
+// wiring pipes, coordinating shutdown of the two directions, and scanning
+// framed messages. Protocol knowledge (where session IDs live, which messages
+// are worth capturing, how messages are framed) is supplied through Hooks and
+// Framing, so a new stdio source is a handful of small functions rather than
+// another copy of the plumbing.
+package stdioproxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/shshwtsuthar/recall/source"
+)
+
+// Direction values emitted by the proxy.
+const (
+	Upstream   = "upstream"   // IDE → agent
+	Downstream = "downstream" // agent → IDE
+)
+
+// Hooks carries the protocol-specific behavior of a stdio source.
+// Every hook is optional and must be safe to call from both pipe goroutines.
+type Hooks struct {
+	// ExtractSession inspects a payload and returns the session ID it
+	// establishes (and that session's workspace, if known), or "" if it
+	// doesn't establish one. The proxy tags every subsequent message with the
+	// most recently extracted session.
+	ExtractSession func(direction, payload string) (id, workspace string)
+
+	// Classify reports whether a payload should be emitted to the pipeline.
+	// Payloads that are not captured are still forwarded unchanged.
+	// A nil Classify captures everything.
+	Classify func(direction, payload string) bool
+
+	// Rewrite, if set, returns the payload to forward in place of the
+	// original. The original is what gets captured. This is the only way a
+	// source modifies traffic, and sources leave it nil unless the user
+	// explicitly asks for a modification (see package prompt).
+	Rewrite func(direction, payload string) string
+}
+
+// Config holds everything needed to run a stdio proxy.
+type Config struct {
+	// Name is the source name stamped on messages and used as the log prefix.
+	Name string
+
+	// Command is the agent binary and its arguments.
+	Command []string
+
+	// Env is appended to the proxy's own environment for the agent process.
+	Env []string
+
+	// Framing delimits messages on both pipes. Defaults to LineFraming.
+	Framing Framing
+
+	// Hooks supplies protocol-specific behavior.
+	Hooks Hooks
+
+	// Stdin, Stdout and Stderr are the IDE-facing streams.
+	// They default to the process's own standard streams.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Proxy runs one agent subprocess and relays its stdio transparently.
+type Proxy struct {
+	config Config
+
+	sessionMu sync.RWMutex
+	sessionID string
+	workspace string
+}
+
+// New creates a Proxy, filling in defaults for unset fields.
+func New(config Config) *Proxy {
+	if config.Framing.Split == nil || config.Framing.Write == nil {
+		config.Framing = LineFraming
+	}
+	if config.Stdin == nil {
+		config.Stdin = os.Stdin
+	}
+	if config.Stdout == nil {
+		config.Stdout = os.Stdout
+	}
+	if config.Stderr == nil {
+		config.Stderr = os.Stderr
+	}
+	return &Proxy{config: config}
+}
+
+// SessionID returns the most recently extracted session ID.
+func (p *Proxy) SessionID() string {
+	id, _ := p.session()
+	return id
+}
+
+func (p *Proxy) session() (id, workspace string) {
+	p.sessionMu.RLock()
+	defer p.sessionMu.RUnlock()
+	return p.sessionID, p.workspace
+}
+
+func (p *Proxy) setSession(id, workspace string) {
+	p.sessionMu.Lock()
+	defer p.sessionMu.Unlock()
+	p.sessionID, p.workspace = id, workspace
+}
+
+// Run spawns the agent subprocess and intercepts bidirectional stdio traffic.
+//
+// Architecture:
+//  1. Spawns agent as subprocess with exec.CommandContext (respects ctx cancellation)
+//  2. Wires stdin/stdout pipes (stderr passes through to the IDE-facing stderr)
+//  3. Launches two goroutines:
+//     - Upstream: IDE → emit Message → agent stdin
+//     - Downstream: agent stdout → emit Message → IDE
+//  4. Waits for both goroutines and subprocess to complete
+//  5. Closes the output channel (ownership model)
+//
+// The IDE and agent see unmodified traffic — they are completely unaware
+// of the proxy's presence. We just observe and emit messages for the pipeline.
+func (p *Proxy) Run(ctx context.Context, out chan<- source.Message) error {
+	if len(p.config.Command) == 0 {
+		close(out)
+		return fmt.Errorf("no agent command specified")
+	}
+
+	agentBinary := p.config.Command[0]
+	cmd := exec.CommandContext(ctx, agentBinary, p.config.Command[1:]...)
+	if len(p.config.Env) > 0 {
+		cmd.Env = append(os.Environ(), p.config.Env...)
+	}
+
+	// Wire up the agent's stdin and stdout.
+	// cmd.Stderr is passed through directly — agent error output goes straight
+	// to our stderr, which is visible in the IDE's dev console.
+	agentStdin, err := cmd.StdinPipe()
+	if err != nil {
+		close(out)
+		return fmt.Errorf("create agent stdin pipe: %w", err)
+	}
+	agentStdout, err := cmd.StdoutPipe()
+	if err != nil {
+		close(out)
+		return fmt.Errorf("create agent stdout pipe: %w", err)
+	}
+	cmd.Stderr = p.config.Stderr
+
+	if err := cmd.Start(); err != nil {
+		close(out)
+		return fmt.Errorf("start agent %q: %w", agentBinary, err)
+	}
+
+	// done is closed when either pipe goroutine finishes, signaling the other
+	// to stop. This prevents goroutine leaks if one side closes early.
+	done := make(chan struct{})
+	closeOnce := sync.Once{}
+	signalDone := func() { closeOnce.Do(func() { close(done) }) }
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		defer signalDone()
+		defer agentStdin.Close()
+		p.pump(ctx, done, Upstream, p.config.Stdin, agentStdin, out)
+	}()
+
+	go func() {
+		defer wg.Done()
+		defer signalDone()
+		p.pump(ctx, done, Downstream, agentStdout, p.config.Stdout, out)
+	}()
+
+	// Wait for both pipe goroutines to finish.
+	wg.Wait()
+
+	// CRITICAL: Source owns the channel lifecycle. We must close it.
+	close(out)
+
+	// Wait for the agent process to exit and return its status.
+	return cmd.Wait()
+}
+
+// pump relays framed messages from src to dst, emitting each one to out
+// before forwarding the ORIGINAL payload (see Hooks.Rewrite for the one
+// exception).
+func (p *Proxy) pump(ctx context.Context, done <-chan struct{}, direction string, src io.Reader, dst io.Writer, out chan<- source.Message) {
+	scanner := newScanner(src, p.config.Framing.Split)
+	for scanner.Scan() {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		payload := scanner.Text()
+
+		// Session extraction is best-effort — we never modify the payload based on it.
+		if p.config.Hooks.ExtractSession != nil {
+			if id, workspace := p.config.Hooks.ExtractSession(direction, payload); id != "" {
+				p.setSession(id, workspace)
+				fmt.Fprintf(p.config.Stderr, "[recall/%s] session started: %s\n", p.config.Name, id)
+			}
+		}
+
+		if p.config.Hooks.Classify == nil || p.config.Hooks.Classify(direction, payload) {
+			sessionID, workspace := p.session()
+			out <- source.Message{
+				Raw:        payload,
+				Direction:  direction,
+				SessionID:  sessionID,
+				Workspace:  workspace,
+				SourceName: p.config.Name,
+				CapturedAt: time.Now().UTC(),
+			}
+		}
+
+		// Forward ORIGINAL (unmodified) payload, unless the user opted into
+		// a rewrite. Write errors are ignored here: a closed peer is noticed
+		// by the opposite pump when its read fails.
+		if p.config.Hooks.Rewrite != nil {
+			payload = p.config.Hooks.Rewrite(direction, payload)
+		}
+		_ = p.config.Framing.Write(dst, payload)
+	}
+
+	if err := scanner.Err(); err != nil && err != io.EOF {
+		fmt.Fprintf(p.config.Stderr, "[recall/%s] %s read error: %v\n", p.config.Name, direction, err)
+	}
+}
+
+// newScanner creates a bufio.Scanner with a generous buffer.
+//
+// Agent messages can be large — a single message may contain the full content
+// of a file the agent read. The default 64KB buffer is too small.
+// We allocate 4MB to handle even very large file reads without dropping data.
+func newScanner(r io.Reader, split bufio.SplitFunc) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4*1024*1024), 4*1024*1024)
+	scanner.Split(split)
+	return scanner
+}
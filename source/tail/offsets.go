@@ -0,0 +1,139 @@
+package tail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fingerprintSize is how many leading bytes identify a file's content.
+// Transcripts start with a timestamped record, so 256 bytes is plenty to
+// tell a rotated file from the one we were reading before.
+const fingerprintSize = 256
+
+// Position is the persisted read state of one file.
+type Position struct {
+	// Offset is the byte offset just past the last line handed to the caller.
+	Offset int64 `json:"offset"`
+
+	// Fingerprint is the hex SHA-256 of the file's first FingerprintLen bytes.
+	// It detects files that were replaced while recall was not running.
+	Fingerprint    string `json:"fingerprint"`
+	FingerprintLen int    `json:"fingerprint_len"`
+
+	// Meta is opaque per-file state owned by the source (e.g. the session a
+	// parser was in when it stopped). The tailer persists it but never reads it.
+	Meta string `json:"meta,omitempty"`
+}
+
+// Offsets is a persisted map of file path → Position.
+//
+// It is written atomically (temp file + rename) so a crash mid-write leaves
+// the previous state intact rather than a half-written file.
+type Offsets struct {
+	path    string
+	existed bool
+
+	mu      sync.Mutex
+	entries map[string]Position
+	dirty   bool
+}
+
+// LoadOffsets reads the state file at path. A missing file is not an error;
+// it yields an empty state and Existed reports false.
+func LoadOffsets(path string) (*Offsets, error) {
+	o := &Offsets{path: path, entries: make(map[string]Position)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read offsets: %w", err)
+	}
+	if err := json.Unmarshal(data, &o.entries); err != nil {
+		return nil, fmt.Errorf("parse offsets %s: %w", path, err)
+	}
+	o.existed = true
+	return o, nil
+}
+
+// Existed reports whether state was loaded from disk, i.e. this is not the
+// first run against this state file.
+func (o *Offsets) Existed() bool {
+	return o.existed
+}
+
+// Get returns the stored position for path.
+func (o *Offsets) Get(path string) (Position, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	pos, ok := o.entries[path]
+	return pos, ok
+}
+
+// Set records the position for path. It is persisted on the next Save.
+func (o *Offsets) Set(path string, pos Position) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.entries[path] = pos
+	o.dirty = true
+}
+
+// SetMeta updates only the opaque Meta of path's position.
+func (o *Offsets) SetMeta(path, meta string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	pos := o.entries[path]
+	if pos.Meta == meta {
+		return
+	}
+	pos.Meta = meta
+	o.entries[path] = pos
+	o.dirty = true
+}
+
+// Save writes the state to disk if anything changed since the last Save.
+func (o *Offsets) Save() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(o.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal offsets: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(o.path), 0o700); err != nil {
+		return fmt.Errorf("create offsets dir: %w", err)
+	}
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write offsets: %w", err)
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		return fmt.Errorf("replace offsets: %w", err)
+	}
+	o.dirty = false
+	o.existed = true
+	return nil
+}
+
+// fingerprint hashes up to n leading bytes of f, returning the hash and
+// the number of bytes actually hashed.
+func fingerprint(f *os.File, n int) (string, int, error) {
+	buf := make([]byte, n)
+	read, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "", 0, err
+	}
+	sum := sha256.Sum256(buf[:read])
+	return hex.EncodeToString(sum[:]), read, nil
+}
@@ -0,0 +1,261 @@
+// Package tail follows append-only line-oriented files (JSONL transcripts,
+// markdown chat logs) across restarts.
+//
+// It polls rather than relying on filesystem notifications: transcripts are
+// written a few times per second at most, polling works identically on every
+// OS, and it needs nothing beyond the standard library. Read positions are
+// persisted through Offsets, and files that are truncated or replaced are
+// detected and re-read from the start.
+package tail
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Line is one complete line read from a followed file.
+type Line struct {
+	// Path is the file the line came from.
+	Path string
+
+	// Text is the line without its trailing newline.
+	Text string
+
+	// Offset is the byte offset at which the line starts.
+	Offset int64
+}
+
+// Config controls which files are followed and how.
+type Config struct {
+	// Name is used as the log prefix, e.g. "claude-cli".
+	Name string
+
+	// Files returns the current set of files to follow, in the order they
+	// should be read. It is called on every poll, so newly created files are
+	// picked up automatically.
+	Files func() ([]string, error)
+
+	// Interval is the poll period. Defaults to one second.
+	Interval time.Duration
+
+	// Offsets persists read positions. Required.
+	Offsets *Offsets
+
+	// Backfill controls files that already exist the very first time recall
+	// runs against Offsets. When false they are followed from their current
+	// end; when true their whole history is emitted. Files discovered later
+	// are always read from the beginning.
+	Backfill bool
+
+	// OnReset, if set, is called when a file is truncated or replaced and
+	// will be re-read from offset zero. Parsers use it to drop partial state.
+	OnReset func(path string)
+
+	// OnRemove, if set, is called when a followed file has disappeared.
+	// Sources use it to drop per-file state.
+	OnRemove func(path string)
+
+	// AfterPoll, if set, is called after every poll pass. Sources use it for
+	// time-based work such as flushing a turn that has stopped growing.
+	AfterPoll func()
+}
+
+// Tailer follows a set of files and hands each new line to a callback.
+type Tailer struct {
+	config Config
+
+	// seen holds the FileInfo from the previous poll, used to detect a file
+	// being replaced underneath a stable path (rename-based rotation).
+	seen map[string]os.FileInfo
+
+	firstPass bool
+}
+
+// New creates a Tailer.
+func New(config Config) *Tailer {
+	if config.Interval <= 0 {
+		config.Interval = time.Second
+	}
+	return &Tailer{
+		config:    config,
+		seen:      make(map[string]os.FileInfo),
+		firstPass: !config.Offsets.Existed(),
+	}
+}
+
+// Run polls until ctx is cancelled, calling emit for every complete line in
+// file order. If emit returns false the line is not considered consumed and
+// Run stops; it will be delivered again after a restart.
+//
+// Positions are saved after every poll pass and once more on return.
+func (t *Tailer) Run(ctx context.Context, emit func(Line) bool) error {
+	defer t.save()
+
+	ticker := time.NewTicker(t.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if !t.poll(ctx, emit) {
+			return nil
+		}
+		t.save()
+		if t.config.AfterPoll != nil {
+			t.config.AfterPoll()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *Tailer) save() {
+	if err := t.config.Offsets.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "[recall/%s] save offsets: %v\n", t.config.Name, err)
+	}
+}
+
+// poll makes one pass over all files. It returns false if emit asked to stop.
+func (t *Tailer) poll(ctx context.Context, emit func(Line) bool) bool {
+	paths, err := t.config.Files()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[recall/%s] list files: %v\n", t.config.Name, err)
+		return ctx.Err() == nil
+	}
+
+	present := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		present[path] = true
+		if !t.follow(path, emit) || ctx.Err() != nil {
+			return false
+		}
+	}
+
+	// Forget files that disappeared. If one reappears it is a new file.
+	for path := range t.seen {
+		if !present[path] {
+			delete(t.seen, path)
+			if t.config.OnRemove != nil {
+				t.config.OnRemove(path)
+			}
+		}
+	}
+	t.firstPass = false
+	return true
+}
+
+// follow reads any new complete lines from one file.
+func (t *Tailer) follow(path string, emit func(Line) bool) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		// Files can vanish between listing and opening; try again next poll.
+		return true
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return true
+	}
+	size := info.Size()
+
+	pos, known := t.config.Offsets.Get(path)
+	prev, seen := t.seen[path]
+	t.seen[path] = info
+
+	switch {
+	case !known:
+		pos = Position{}
+		if t.firstPass && !t.config.Backfill {
+			pos.Offset = lastLineEnd(f, size)
+		}
+	case seen && !os.SameFile(prev, info):
+		t.reset(path, &pos, "replaced")
+	case size < pos.Offset:
+		t.reset(path, &pos, "truncated")
+	case pos.FingerprintLen > 0:
+		// Make sure it is still the file we were reading, on every poll: a
+		// file truncated and rewritten past our offset between two polls
+		// keeps its identity and size but not its start. This reads at most
+		// fingerprintSize bytes.
+		if sum, n, err := fingerprint(f, pos.FingerprintLen); err != nil || n != pos.FingerprintLen || sum != pos.Fingerprint {
+			t.reset(path, &pos, "replaced")
+		}
+	}
+
+	if pos.FingerprintLen < fingerprintSize && size > int64(pos.FingerprintLen) {
+		if sum, n, err := fingerprint(f, fingerprintSize); err == nil {
+			pos.Fingerprint, pos.FingerprintLen = sum, n
+		}
+	}
+
+	defer func() {
+		// Keep any Meta the source set from inside emit.
+		if cur, ok := t.config.Offsets.Get(path); ok {
+			pos.Meta = cur.Meta
+		}
+		t.config.Offsets.Set(path, pos)
+	}()
+
+	if size == pos.Offset {
+		return true
+	}
+
+	// Only read up to the size we just observed; anything appended while we
+	// read is picked up on the next poll.
+	r := bufio.NewReader(io.NewSectionReader(f, pos.Offset, size-pos.Offset))
+	for {
+		raw, err := r.ReadString('\n')
+		if err != nil {
+			// EOF with a partial line: the writer hasn't finished it yet.
+			return true
+		}
+		line := Line{
+			Path:   path,
+			Text:   strings.TrimRight(raw, "\r\n"),
+			Offset: pos.Offset,
+		}
+		if !emit(line) {
+			return false
+		}
+		pos.Offset += int64(len(raw))
+	}
+}
+
+func (t *Tailer) reset(path string, pos *Position, why string) {
+	fmt.Fprintf(os.Stderr, "[recall/%s] %s was %s, reading from start\n", t.config.Name, path, why)
+	*pos = Position{}
+	t.config.Offsets.Set(path, *pos)
+	if t.config.OnReset != nil {
+		t.config.OnReset(path)
+	}
+}
+
+// lastLineEnd returns the offset just past the last newline in the first
+// size bytes of f, so following "from the end" never starts mid-line.
+func lastLineEnd(f *os.File, size int64) int64 {
+	const chunk = 4096
+	buf := make([]byte, chunk)
+	for end := size; end > 0; {
+		start := end - chunk
+		if start < 0 {
+			start = 0
+		}
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return size
+		}
+		if i := strings.LastIndexByte(string(buf[:n]), '\n'); i >= 0 {
+			return start + int64(i) + 1
+		}
+		end = start
+	}
+	return 0
+}
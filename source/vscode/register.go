@@ -0,0 +1,28 @@
+package vscode
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/shshwtsuthar/recall/source"
+)
+
+func init() {
+	source.Register(source.Descriptor{
+		Name:        "vscode",
+		Description: "Accept interactions pushed by an editor extension over a localhost WebSocket",
+		Flags: []source.Flag{
+			{Name: "port", Usage: "localhost WebSocket port", Default: strconv.Itoa(DefaultPort)},
+		},
+		New: func(opts source.Options) (source.Source, error) {
+			port, err := opts.Int("port")
+			if err != nil {
+				return nil, err
+			}
+			if port <= 0 || port > 65535 {
+				return nil, fmt.Errorf("--port must be a TCP port number, got %d", port)
+			}
+			return New(Config{WebSocketPort: port}), nil
+		},
+	})
+}
@@ -0,0 +1,414 @@
+// Package vscode implements the Source interface for editor extensions that
+// push agent interactions to recall over a localhost WebSocket.
+//
+// Unlike ACP, the extension already sees the traffic, so recall proxies
+// nothing: the extension connects to ws://127.0.0.1:<port>/v1/stream and
+// sends JSON text messages.
+//
+// Protocol (version 1):
+//
+//  1. Handshake. The first message must be
+//
+//     {"type":"hello","protocol":1,"token":"<token>","workspace":"/abs/path","conversation":"optional-id"}
+//
+//     The token is read from the token file in recall's data directory
+//     (vscode.token, created with mode 0600 on first start), so only the
+//     local user can connect. recall answers
+//
+//     {"type":"welcome","protocol":1,"session_id":"vscode-…","window":64}
+//
+//     or {"type":"error","error":"…"} followed by a close frame.
+//
+//  2. Messages. Each captured interaction is sent as
+//
+//     {"type":"message","seq":1,"direction":"upstream","raw":"…","captured_at":"RFC3339"}
+//
+//     seq is chosen by the client and echoed back as {"type":"ack","seq":1}
+//     once the message has been accepted into the pipeline.
+//
+//  3. Backpressure. The client must keep at most "window" messages
+//     unacknowledged. recall acknowledges only after the pipeline accepts a
+//     message, so a slow pipeline stalls acks and the extension buffers or
+//     drops on its side rather than recall growing without bound.
+//
+// Session IDs are derived per workspace (and optional conversation): the same
+// workspace keeps the same session for the lifetime of the recall process,
+// even across reconnects. The workspace path itself is only hashed.
+package vscode
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shshwtsuthar/recall/internal/appdir"
+	"github.com/shshwtsuthar/recall/source"
+)
+
+const (
+	// DefaultPort is used when Config.WebSocketPort is zero.
+	DefaultPort = 7457
+
+	// protocolVersion is the extension protocol version spoken by this source.
+	protocolVersion = 1
+
+	// defaultWindow is the number of unacknowledged messages a client may have.
+	defaultWindow = 64
+
+	// helloTimeout bounds how long a connection may sit unauthenticated.
+	helloTimeout = 10 * time.Second
+)
+
+// Config holds VS Code extension-specific configuration.
+type Config struct {
+	// WebSocketPort is the localhost port to listen on. Defaults to DefaultPort.
+	WebSocketPort int
+
+	// TokenFile holds the shared secret clients must present.
+	// Defaults to vscode.token in recall's data directory.
+	TokenFile string
+
+	// Window is the maximum number of unacknowledged messages per connection.
+	Window int
+}
+
+// Source implements the source.Source interface for editor extensions.
+type Source struct {
+	config Config
+	token  string
+
+	// nonce makes session IDs unique to this recall process, so reopening a
+	// workspace tomorrow starts a new trajectory.
+	nonce string
+
+	sessionsMu sync.Mutex
+	sessions   map[string]string
+
+	connsMu sync.Mutex
+	conns   map[*wsConn]struct{}
+	closing bool
+}
+
+// New creates a VS Code source with the given configuration.
+func New(config Config) *Source {
+	if config.WebSocketPort == 0 {
+		config.WebSocketPort = DefaultPort
+	}
+	if config.Window <= 0 {
+		config.Window = defaultWindow
+	}
+	return &Source{
+		config:   config,
+		nonce:    randomHex(8),
+		sessions: make(map[string]string),
+		conns:    make(map[*wsConn]struct{}),
+	}
+}
+
+// Name returns the identifier for this source type.
+func (s *Source) Name() string {
+	return "vscode"
+}
+
+// clientFrame is any message sent by the extension.
+type clientFrame struct {
+	Type string `json:"type"`
+
+	// hello
+	Protocol     int    `json:"protocol"`
+	Token        string `json:"token"`
+	Workspace    string `json:"workspace"`
+	Conversation string `json:"conversation"`
+
+	// message
+	Seq        uint64 `json:"seq"`
+	Direction  string `json:"direction"`
+	Raw        string `json:"raw"`
+	CapturedAt string `json:"captured_at"`
+}
+
+// serverFrame is any message sent to the extension.
+type serverFrame struct {
+	Type      string `json:"type"`
+	Protocol  int    `json:"protocol,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	Window    int    `json:"window,omitempty"`
+	Seq       uint64 `json:"seq"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Run listens for extension connections until ctx is cancelled.
+func (s *Source) Run(ctx context.Context, out chan<- source.Message) error {
+	token, err := s.loadToken()
+	if err != nil {
+		close(out)
+		return err
+	}
+	s.token = token
+
+	addr := fmt.Sprintf("127.0.0.1:%d", s.config.WebSocketPort)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		close(out)
+		return fmt.Errorf("listen on %s: %w", addr, err)
+	}
+
+	var handlers sync.WaitGroup
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/stream", func(w http.ResponseWriter, r *http.Request) {
+		// Counted under connsMu so no handler starts once shutdown is
+		// waiting for them.
+		s.connsMu.Lock()
+		if s.closing {
+			s.connsMu.Unlock()
+			http.Error(w, "recall shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		handlers.Add(1)
+		s.connsMu.Unlock()
+		defer handlers.Done()
+		s.serve(ctx, w, r, out)
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: helloTimeout}
+
+	fmt.Fprintf(os.Stderr, "[recall/vscode] listening on ws://%s/v1/stream\n", addr)
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(ln) }()
+
+	select {
+	case <-ctx.Done():
+		err = nil
+	case err = <-serveErr:
+		err = fmt.Errorf("serve: %w", err)
+	}
+
+	// Hijacked connections are not tracked by http.Server, so close them
+	// ourselves before waiting for their handlers to exit.
+	srv.Close()
+	s.connsMu.Lock()
+	s.closing = true
+	for conn := range s.conns {
+		conn.Close(1001, "recall shutting down")
+	}
+	s.connsMu.Unlock()
+	handlers.Wait()
+
+	// CRITICAL: Source owns the channel lifecycle. We must close it.
+	close(out)
+	return err
+}
+
+// serve handles one extension connection from handshake to close.
+func (s *Source) serve(ctx context.Context, w http.ResponseWriter, r *http.Request, out chan<- source.Message) {
+	// Browsers attach an Origin; editor extensions running in Node do not.
+	// Refusing web origins stops a page from talking to us via the browser.
+	if origin := r.Header.Get("Origin"); origin != "" && !strings.HasPrefix(origin, "vscode-") {
+		http.Error(w, "forbidden origin", http.StatusForbidden)
+		return
+	}
+
+	conn, err := upgrade(w, r)
+	if err != nil {
+		return
+	}
+	s.track(conn, true)
+	defer s.track(conn, false)
+	defer conn.Close(1000, "")
+
+	sessionID, workspace, err := s.handshake(conn)
+	if err != nil {
+		s.reply(conn, serverFrame{Type: "error", Error: err.Error()})
+		fmt.Fprintf(os.Stderr, "[recall/vscode] rejected connection: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[recall/vscode] session started: %s\n", sessionID)
+
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			if !errors.Is(err, errClosed) && !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "[recall/vscode] read error: %v\n", err)
+			}
+			return
+		}
+
+		var frame clientFrame
+		if err := json.Unmarshal(data, &frame); err != nil || frame.Type != "message" {
+			s.reply(conn, serverFrame{Type: "error", Seq: frame.Seq, Error: "expected a message frame"})
+			continue
+		}
+		msg, err := s.toMessage(frame, sessionID, workspace)
+		if err != nil {
+			s.reply(conn, serverFrame{Type: "error", Seq: frame.Seq, Error: err.Error()})
+			continue
+		}
+
+		// Blocking here is the backpressure: no ack until the pipeline takes it.
+		select {
+		case out <- msg:
+		case <-ctx.Done():
+			return
+		}
+		s.reply(conn, serverFrame{Type: "ack", Seq: frame.Seq})
+	}
+}
+
+// handshake reads and validates the hello frame, returning the session ID
+// and the workspace path it was derived from.
+func (s *Source) handshake(conn *wsConn) (sessionID, workspace string, err error) {
+	conn.conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	defer conn.conn.SetReadDeadline(time.Time{})
+
+	data, err := conn.ReadMessage()
+	if err != nil {
+		return "", "", fmt.Errorf("read hello: %w", err)
+	}
+	var hello clientFrame
+	if err := json.Unmarshal(data, &hello); err != nil || hello.Type != "hello" {
+		return "", "", fmt.Errorf("first message must be hello")
+	}
+	if subtle.ConstantTimeCompare([]byte(hello.Token), []byte(s.token)) != 1 {
+		return "", "", fmt.Errorf("invalid token")
+	}
+	if hello.Protocol != protocolVersion {
+		return "", "", fmt.Errorf("unsupported protocol %d (want %d)", hello.Protocol, protocolVersion)
+	}
+	if hello.Workspace == "" {
+		return "", "", fmt.Errorf("hello requires workspace")
+	}
+
+	sessionID = s.session(hello.Workspace, hello.Conversation)
+	err = s.reply(conn, serverFrame{
+		Type:      "welcome",
+		Protocol:  protocolVersion,
+		SessionID: sessionID,
+		Window:    s.config.Window,
+	})
+	return sessionID, hello.Workspace, err
+}
+
+// toMessage validates a message frame and converts it.
+func (s *Source) toMessage(frame clientFrame, sessionID, workspace string) (source.Message, error) {
+	switch frame.Direction {
+	case "upstream", "downstream", "log":
+	default:
+		return source.Message{}, fmt.Errorf("invalid direction %q", frame.Direction)
+	}
+
+	capturedAt := time.Now().UTC()
+	if frame.CapturedAt != "" {
+		t, err := time.Parse(time.RFC3339Nano, frame.CapturedAt)
+		if err != nil {
+			return source.Message{}, fmt.Errorf("invalid captured_at: %w", err)
+		}
+		capturedAt = t.UTC()
+	}
+
+	return source.Message{
+		Raw:        frame.Raw,
+		Direction:  frame.Direction,
+		SessionID:  sessionID,
+		Workspace:  workspace,
+		SourceName: s.Name(),
+		CapturedAt: capturedAt,
+	}, nil
+}
+
+func (s *Source) reply(conn *wsConn, frame serverFrame) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	return conn.WriteText(data)
+}
+
+// session returns the stable session ID for a workspace/conversation pair.
+func (s *Source) session(workspace, conversation string) string {
+	key := workspace + "\x00" + conversation
+
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	if id, ok := s.sessions[key]; ok {
+		return id
+	}
+	sum := sha256.Sum256([]byte(s.nonce + "\x00" + key))
+	id := "vscode-" + hex.EncodeToString(sum[:8])
+	s.sessions[key] = id
+	return id
+}
+
+func (s *Source) track(conn *wsConn, add bool) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if add && s.closing {
+		// Upgraded after shutdown began; unblock the handler immediately.
+		conn.conn.Close()
+	} else if add {
+		s.conns[conn] = struct{}{}
+	} else {
+		delete(s.conns, conn)
+	}
+}
+
+// loadToken reads the shared secret, creating it on first use.
+// A token file readable by other users is refused rather than silently fixed.
+func (s *Source) loadToken() (string, error) {
+	path := s.config.TokenFile
+	if path == "" {
+		var err error
+		if path, err = appdir.Path("vscode.token"); err != nil {
+			return "", err
+		}
+	}
+
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		token := randomHex(32)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return "", fmt.Errorf("create token file: %w", err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(token + "\n"); err != nil {
+			return "", fmt.Errorf("write token file: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "[recall/vscode] created token file %s\n", path)
+		return token, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("stat token file: %w", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		return "", fmt.Errorf("token file %s is accessible by other users (mode %v); run chmod 600", path, info.Mode().Perm())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,15 @@
+package main
+
+// Sources compiled into this binary. Each package registers itself with
+// package source from an init function, so adding a source means adding an
+// import here — or in a separate file of package main, which is how forks
+// ship their internal sources without touching this list.
+import (
+	_ "github.com/shshwtsuthar/recall/source/acp"
+	_ "github.com/shshwtsuthar/recall/source/aider"
+	_ "github.com/shshwtsuthar/recall/source/claudecli"
+	_ "github.com/shshwtsuthar/recall/source/execplugin"
+	_ "github.com/shshwtsuthar/recall/source/httpllm"
+	_ "github.com/shshwtsuthar/recall/source/pty"
+	_ "github.com/shshwtsuthar/recall/source/vscode"
+)